@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupSQLite copies the sqlite database at src into dest with sqlite's online
+// backup API, which works while lightningd is writing to src and always yields
+// a consistent copy. It returns the data_version of src the copy corresponds
+// to.
+func backupSQLite(src, dest string) (dataVersion int64, err error) {
+	sqliteDriver := &sqlite3.SQLiteDriver{}
+
+	srcConn, err := sqliteDriver.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("opening %s: %w", src, err)
+	}
+	defer srcConn.Close()
+
+	destConn, err := sqliteDriver.Open(dest)
+	if err != nil {
+		return 0, fmt.Errorf("opening %s: %w", dest, err)
+	}
+	defer destConn.Close()
+
+	backup, err := destConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+	if err != nil {
+		return 0, fmt.Errorf("starting backup: %w", err)
+	}
+	defer backup.Close()
+
+	// copying all pages in one step holds a read lock on src until the end, so
+	// nothing written meanwhile can end up half in the copy. while lightningd
+	// holds a write lock the step just reports busy and we try again.
+	deadline := time.Now().Add(time.Minute)
+	for {
+		done, err := backup.Step(-1)
+		if err != nil {
+			return 0, fmt.Errorf("copying pages: %w", err)
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("%s stayed locked for too long", src)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// still under the read lock, so nothing was written since the pages
+	dataVersion, err = pragmaInt(srcConn, "data_version")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := backup.Finish(); err != nil {
+		return 0, fmt.Errorf("finishing backup: %w", err)
+	}
+	return dataVersion, nil
+}
+
+func pragmaInt(conn driver.Conn, pragma string) (int64, error) {
+	rows, err := conn.(*sqlite3.SQLiteConn).Query("PRAGMA "+pragma, nil)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s: %w", pragma, err)
+	}
+	defer rows.Close()
+
+	values := make([]driver.Value, 1)
+	if err := rows.Next(values); err != nil {
+		return 0, fmt.Errorf("reading %s: %w", pragma, err)
+	}
+	value, ok := values[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %s: %v", pragma, values[0])
+	}
+	return value, nil
+}
+
+// checkSQLite runs sqlite's own consistency checks on the database at path.
+func checkSQLite(path string) error {
+	db, err := sqlx.Connect("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var problems []string
+	if err := db.Select(&problems, "PRAGMA integrity_check"); err != nil {
+		return fmt.Errorf("running integrity_check: %w", err)
+	}
+	if len(problems) != 1 || problems[0] != "ok" {
+		return fmt.Errorf("integrity_check failed: %s", strings.Join(problems, "; "))
+	}
+
+	var violations []struct {
+		Table  string        `db:"table"`
+		Rowid  sql.NullInt64 `db:"rowid"`
+		Parent string        `db:"parent"`
+		Fkid   int           `db:"fkid"`
+	}
+	if err := db.Select(&violations, "PRAGMA foreign_key_check"); err != nil {
+		return fmt.Errorf("running foreign_key_check: %w", err)
+	}
+	if len(violations) > 0 {
+		for _, v := range violations {
+			fmt.Printf("      %s row %d references a missing %s row\n", v.Table, v.Rowid.Int64, v.Parent)
+		}
+		return fmt.Errorf("foreign_key_check found %d rows referencing missing rows", len(violations))
+	}
+
+	return nil
+}
+
+// backupBeside writes a timestamped copy of the sqlite database at path next to
+// it and returns where it is, its sha256 and the data_version it corresponds to.
+func backupBeside(path string) (backupPath string, sum string, dataVersion int64, err error) {
+	backupPath = path + "." + time.Now().Format("20060102-150405") + ".bak"
+	if _, err := os.Stat(backupPath); err == nil {
+		return "", "", 0, fmt.Errorf("%s already exists", backupPath)
+	}
+
+	dataVersion, err = backupSQLite(path, backupPath)
+	if err != nil {
+		os.Remove(backupPath)
+		return "", "", 0, err
+	}
+
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", "", 0, err
+	}
+
+	return backupPath, hex.EncodeToString(h.Sum(nil)), dataVersion, nil
+}
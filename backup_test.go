@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestBackupSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mcldsp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "lightningd.sqlite3")
+	db, err := sqlx.Connect("sqlite3", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE vars (name VARCHAR(32), val VARCHAR(255), PRIMARY KEY (name))`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO vars VALUES ('next_pay_index', '7')`); err != nil {
+		t.Fatal(err)
+	}
+
+	backupPath, sum, dataVersion, err := backupBeside(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sum) != 64 {
+		t.Errorf("sha256 = %q", sum)
+	}
+	if dataVersion <= 0 {
+		t.Errorf("data_version = %d", dataVersion)
+	}
+
+	backup, err := sqlx.Connect("sqlite3", backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	var val string
+	if err := backup.Get(&val, `SELECT val FROM vars WHERE name = 'next_pay_index'`); err != nil {
+		t.Fatal(err)
+	}
+	if val != "7" {
+		t.Errorf("val = %q, want 7", val)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// bulkCopy is a COPY FROM STDIN into a staging table with the same columns as
+// the real one. Once every row is streamed the staging table is merged into the
+// real table with the same conflict handling the row-by-row path has. It is
+// not a TEMPORARY table because those can't be part of a prepared transaction
+// (see copyInParallel), but it only lives inside the copy's transaction.
+type bulkCopy struct {
+	pgx         *sqlx.Tx
+	tableName   string
+	staging     string
+	columnnames []string
+	stmt        *sql.Stmt
+}
+
+func startBulk(pgx *sqlx.Tx, tableName string, columnnames []string) (*bulkCopy, error) {
+	staging := "mcldsp_staging_" + tableName
+	_, err := pgx.Exec(`CREATE UNLOGGED TABLE ` + staging + ` AS
+SELECT ` + strings.Join(columnnames, ",") + ` FROM ` + tableName + ` WITH NO DATA`)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := pgx.Prepare(pq.CopyIn(staging, columnnames...))
+	if err != nil {
+		return nil, err
+	}
+
+	return &bulkCopy{
+		pgx:         pgx,
+		tableName:   tableName,
+		staging:     staging,
+		columnnames: columnnames,
+		stmt:        stmt,
+	}, nil
+}
+
+// merge flushes the COPY and inserts the staged rows into the real table,
+// returning how many rows were inserted or updated.
+func (b *bulkCopy) merge(conflictStmt string) (affected int, err error) {
+	if err := b.flush(); err != nil {
+		return 0, err
+	}
+
+	result, err := b.pgx.Exec(mergeStmt(b.tableName, b.staging, b.columnnames, conflictStmt))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := b.pgx.Exec(`DROP TABLE ` + b.staging); err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// conflicts flushes the COPY and returns the keys of the staged rows that
+// already exist on the real table.
+func (b *bulkCopy) conflicts(t table, columns []column) (keys []string, err error) {
+	if err := b.flush(); err != nil {
+		return nil, err
+	}
+
+	var keyColumns []column
+	for _, key := range t.keys() {
+		for _, col := range columns {
+			if col.Name == key {
+				keyColumns = append(keyColumns, col)
+			}
+		}
+	}
+
+	rows, err := b.pgx.Query(existingKeysQuery(t, b.staging))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		targets := make([]interface{}, len(keyColumns))
+		for i, col := range keyColumns {
+			targets[i] = col.scanTarget()
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+		keys = append(keys, keyDump(t, keyColumns, targets))
+	}
+
+	return keys, rows.Err()
+}
+
+// mergeStmt inserts every staged row into the real table.
+func mergeStmt(tableName, staging string, columnnames []string, conflictStmt string) string {
+	columns := strings.Join(columnnames, ",")
+	return `
+INSERT INTO ` + tableName + ` (` + columns + `)
+SELECT ` + columns + ` FROM ` + staging + `
+` + conflictStmt
+}
+
+// existingKeysQuery selects the keys of the staged rows already on the real
+// table.
+func existingKeysQuery(t table, staging string) string {
+	var conditions []string
+	for _, key := range t.keys() {
+		conditions = append(conditions, "t."+key+" = s."+key)
+	}
+	return `
+SELECT ` + t.unique + ` FROM ` + staging + ` AS s
+WHERE EXISTS (SELECT 1 FROM ` + t.name + ` AS t WHERE ` + strings.Join(conditions, " AND ") + `)
+    `
+}
+
+// flush ends the COPY, after this the staging table can be queried.
+func (b *bulkCopy) flush() error {
+	if b.stmt == nil {
+		return nil
+	}
+	if _, err := b.stmt.Exec(); err != nil {
+		return err
+	}
+	if err := b.stmt.Close(); err != nil {
+		return err
+	}
+	b.stmt = nil
+	return nil
+}
+
+func (b *bulkCopy) close() {
+	if b.stmt != nil {
+		b.stmt.Close()
+	}
+}
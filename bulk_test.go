@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeStmt(t *testing.T) {
+	tests := []struct {
+		name         string
+		conflictStmt string
+		stmt         string
+	}{
+		{
+			name:         "skip",
+			conflictStmt: "ON CONFLICT (id) DO NOTHING",
+			stmt:         "INSERT INTO peers (id,node_id) SELECT id,node_id FROM mcldsp_staging_peers ON CONFLICT (id) DO NOTHING",
+		},
+		{
+			name:         "update",
+			conflictStmt: "ON CONFLICT (id) DO UPDATE SET node_id=EXCLUDED.node_id",
+			stmt:         "INSERT INTO peers (id,node_id) SELECT id,node_id FROM mcldsp_staging_peers ON CONFLICT (id) DO UPDATE SET node_id=EXCLUDED.node_id",
+		},
+		{
+			name: "table without key",
+			stmt: "INSERT INTO peers (id,node_id) SELECT id,node_id FROM mcldsp_staging_peers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeStmt("peers", "mcldsp_staging_peers", []string{"id", "node_id"}, tt.conflictStmt)
+			if got := strings.Join(strings.Fields(got), " "); got != tt.stmt {
+				t.Errorf("got %q, want %q", got, tt.stmt)
+			}
+		})
+	}
+}
+
+func TestExistingKeysQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		table table
+		query string
+	}{
+		{
+			name:  "single key",
+			table: table{"peers", "id"},
+			query: "SELECT id FROM mcldsp_staging AS s WHERE EXISTS (SELECT 1 FROM peers AS t WHERE t.id = s.id)",
+		},
+		{
+			name:  "composite key with spaces",
+			table: table{"channel_feerates", "channel_id, hstate"},
+			query: "SELECT channel_id, hstate FROM mcldsp_staging AS s WHERE EXISTS (SELECT 1 FROM channel_feerates AS t WHERE t.channel_id = s.channel_id AND t.hstate = s.hstate)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := existingKeysQuery(tt.table, "mcldsp_staging")
+			if got := strings.Join(strings.Fields(got), " "); got != tt.query {
+				t.Errorf("got %q, want %q", got, tt.query)
+			}
+		})
+	}
+}
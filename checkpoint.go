@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// checkpoint is how far a table got in a resumable migration. hash is the
+// chainHash of every sqlite row copied so far, so a rerun can tell whether the
+// source changed under it.
+type checkpoint struct {
+	Table     string `db:"table_name"`
+	LastRowid int64  `db:"last_rowid"`
+	Rows      int    `db:"rows_copied"`
+	Hash      string `db:"source_hash"`
+	Done      bool   `db:"done"`
+}
+
+func loadCheckpoints() (map[string]checkpoint, error) {
+	_, err := pg.Exec(`
+CREATE TABLE IF NOT EXISTS mcldsp_checkpoint (
+  table_name TEXT PRIMARY KEY,
+  last_rowid BIGINT NOT NULL,
+  rows_copied BIGINT NOT NULL,
+  source_hash TEXT NOT NULL,
+  done BOOLEAN NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT now()
+)
+    `)
+	if err != nil {
+		fmt.Println("error creating checkpoint table", err)
+		return nil, err
+	}
+
+	var list []checkpoint
+	err = pg.Select(&list, `SELECT table_name, last_rowid, rows_copied, source_hash, done FROM mcldsp_checkpoint`)
+	if err != nil {
+		fmt.Println("error loading checkpoints", err)
+		return nil, err
+	}
+
+	checkpoints := make(map[string]checkpoint, len(list))
+	for _, cp := range list {
+		checkpoints[cp.Table] = cp
+	}
+	return checkpoints, nil
+}
+
+// copyRowsResumable copies a table in batches of batchSize rows (the whole
+// table at once if batchSize is 0), committing each batch together with its
+// checkpoint. It starts from cp, after making sure the sqlite rows it covers
+// are still the ones that were copied.
+func copyRowsResumable(t table, cp checkpoint, opts copyOptions, batchSize int) (stats tableStats, err error) {
+	stats.table = t.name
+	cp.Table = t.name
+
+	if cp.LastRowid > 0 || cp.Done {
+		upTo := cp.LastRowid
+		if cp.Done {
+			// rows added after the copy must be noticed too
+			upTo = math.MaxInt64
+		}
+		hash, err := sourceHash(t.name, upTo)
+		if err != nil {
+			return stats, err
+		}
+		if hash != cp.Hash {
+			err = fmt.Errorf("sqlite rows of %s changed since they were copied, can't resume", t.name)
+			fmt.Println(err)
+			return stats, err
+		}
+		if cp.Done {
+			fmt.Printf("  > %s was already copied (%d rows).\n", t.name, cp.Rows)
+			return stats, nil
+		}
+		fmt.Printf("  > resuming %s after %d rows.\n", t.name, cp.Rows)
+	}
+
+	for {
+		pgx, err := pg.Beginx()
+		if err != nil {
+			return stats, err
+		}
+
+		var deferred []foreignKey
+		for _, fk := range opts.deferred {
+			if fk.Table == t.name {
+				deferred = append(deferred, fk)
+			}
+		}
+		if err := deferConstraints(pgx, deferred); err != nil {
+			pgx.Rollback()
+			return stats, err
+		}
+
+		opts.after = cp.LastRowid
+		opts.limit = batchSize
+		opts.checkpoint = true
+		opts.chain, _ = hex.DecodeString(cp.Hash)
+		batch, err := copyRows(pgx, t, opts)
+		if err != nil {
+			pgx.Rollback()
+			return stats, err
+		}
+
+		if err := restoreConstraints(pgx, deferred); err != nil {
+			pgx.Rollback()
+			return stats, err
+		}
+
+		cp.LastRowid = batch.lastRowid
+		cp.Rows += batch.read
+		cp.Hash = hex.EncodeToString(batch.chain)
+		cp.Done = batchSize == 0 || batch.read < batchSize
+		_, err = pgx.NamedExec(`
+INSERT INTO mcldsp_checkpoint (table_name, last_rowid, rows_copied, source_hash, done)
+VALUES (:table_name, :last_rowid, :rows_copied, :source_hash, :done)
+ON CONFLICT (table_name) DO UPDATE SET last_rowid=:last_rowid, rows_copied=:rows_copied,
+  source_hash=:source_hash, done=:done, updated_at=now()
+        `, cp)
+		if err != nil {
+			pgx.Rollback()
+			fmt.Println("error saving checkpoint for "+t.name, err)
+			return stats, err
+		}
+		if err := pgx.Commit(); err != nil {
+			fmt.Println("error committing "+t.name+" batch", err)
+			return stats, err
+		}
+
+		stats.read += batch.read
+		stats.written += batch.written
+		stats.skipped += batch.skipped
+		stats.updated += batch.updated
+		stats.conflicts = append(stats.conflicts, batch.conflicts...)
+		stats.lastRowid = batch.lastRowid
+		if cp.Done {
+			return stats, nil
+		}
+	}
+}
+
+// sourceHash recomputes the chainHash of the sqlite rows of a table up to the
+// given rowid.
+func sourceHash(tableName string, upTo int64) (string, error) {
+	pgx, err := pg.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer pgx.Rollback()
+
+	columns, err := describeTable(pgx, tableName)
+	if err != nil {
+		return "", err
+	}
+	columnnames := make([]string, len(columns))
+	for i, col := range columns {
+		columnnames[i] = col.Name
+	}
+
+	rows, err := lite.Query(`SELECT `+strings.Join(columnnames, ",")+` FROM `+tableName+`
+WHERE rowid <= ? ORDER BY rowid`, upTo)
+	if err != nil {
+		fmt.Println("error selecting "+tableName, err)
+		return "", err
+	}
+	defer rows.Close()
+
+	var chain []byte
+	for rows.Next() {
+		targets := make([]interface{}, len(columns))
+		for i, col := range columns {
+			targets[i] = col.scanTarget()
+		}
+		if err := rows.Scan(targets...); err != nil {
+			fmt.Println("error scanning "+tableName+" row", err)
+			return "", err
+		}
+		chain = chainHash(chain, targets)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(chain), nil
+}
+
+// chainHash extends a hash chain with one more row.
+func chainHash(prev []byte, targets []interface{}) []byte {
+	h := sha256.New()
+	h.Write(prev)
+	h.Write(rowHash(targets))
+	return h.Sum(nil)
+}
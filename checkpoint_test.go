@@ -0,0 +1,70 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"testing"
+)
+
+func TestChainHash(t *testing.T) {
+	row := func(id int64, name string) []interface{} {
+		return []interface{}{&sql.NullInt64{Int64: id, Valid: true}, &sql.NullString{String: name, Valid: true}}
+	}
+	chain := func(rows ...[]interface{}) string {
+		var c []byte
+		for _, r := range rows {
+			c = chainHash(c, r)
+		}
+		return hex.EncodeToString(c)
+	}
+
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		same bool
+	}{
+		{"no rows", chain(), "", true},
+		{"same rows", chain(row(1, "a"), row(2, "b")), chain(row(1, "a"), row(2, "b")), true},
+		{"order matters", chain(row(1, "a"), row(2, "b")), chain(row(2, "b"), row(1, "a")), false},
+		{"a changed row", chain(row(1, "a"), row(2, "b")), chain(row(1, "a"), row(2, "c")), false},
+		{"a row more", chain(row(1, "a")), chain(row(1, "a"), row(2, "b")), false},
+		{"a row less at the start", chain(row(1, "a"), row(2, "b")), chain(row(2, "b")), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := tt.a == tt.b; same != tt.same {
+				t.Errorf("%s and %s: same = %v, want %v", tt.a, tt.b, same, tt.same)
+			}
+		})
+	}
+}
+
+// a resumed copy picks the chain up from the hex saved in its checkpoint.
+func TestChainHashResumes(t *testing.T) {
+	rows := [][]interface{}{
+		{&sql.NullInt64{Int64: 1, Valid: true}},
+		{&sql.NullInt64{Int64: 2, Valid: true}},
+		{&sql.NullInt64{}},
+	}
+
+	var whole []byte
+	for _, r := range rows {
+		whole = chainHash(whole, r)
+	}
+
+	for split := 0; split <= len(rows); split++ {
+		var first []byte
+		for _, r := range rows[:split] {
+			first = chainHash(first, r)
+		}
+		resumed, _ := hex.DecodeString(hex.EncodeToString(first))
+		for _, r := range rows[split:] {
+			resumed = chainHash(resumed, r)
+		}
+		if hex.EncodeToString(resumed) != hex.EncodeToString(whole) {
+			t.Errorf("resuming after %d rows gives another hash", split)
+		}
+	}
+}
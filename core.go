@@ -1,92 +1,319 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/kr/pretty"
 )
 
-func copyRows(pgx *sqlx.Tx, tableName string, kind interface{}, unique string) (err error) {
-	typ := reflect.TypeOf(kind)
-	if typ.Kind() != reflect.Struct {
-		return errors.New("kind given to copyRows is not a struct")
+// tableStats is what happened to a table during the copy.
+type tableStats struct {
+	table   string
+	read    int
+	written int
+	skipped int
+	updated int
+
+	// conflicts has the key of every row that already existed on postgres
+	conflicts []string
+
+	lastRowid int64  // sqlite rowid of the last row read
+	chain     []byte // see chainHash, only computed when checkpointing
+
+	duration time.Duration
+}
+
+func (s tableStats) String() string {
+	rate := 0.0
+	if s.duration > 0 {
+		rate = float64(s.read) / s.duration.Seconds()
 	}
+	return fmt.Sprintf("%-28s read %d, written %d, skipped %d, updated %d (%.0f rows/s)",
+		s.table, s.read, s.written, s.skipped, s.updated, rate)
+}
 
-	nfields := typ.NumField()
-	columnnames := make([]string, nfields)
-	valuelabels := make([]string, nfields)
-	values := make([]interface{}, nfields)
-	for i := 0; i < nfields; i++ {
-		valuelabels[i] = fmt.Sprintf("$%d", i+1)
-		columnnames[i] = typ.Field(i).Tag.Get("db")
+// rows read from sqlite at a time when copyOptions doesn't say otherwise.
+const defaultChunkSize = 10000
+
+// peakMemory is the highest heap usage seen between chunks, by any of the
+// -jobs connections.
+var (
+	peakMemory   uint64
+	peakMemoryMu sync.Mutex
+)
+
+func notePeakMemory() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	peakMemoryMu.Lock()
+	defer peakMemoryMu.Unlock()
+	if m.HeapAlloc > peakMemory {
+		peakMemory = m.HeapAlloc
 	}
+}
+
+// what to do with a sqlite row whose key already exists on postgres.
+const (
+	conflictSkip   = "skip"
+	conflictUpdate = "update"
+	conflictFail   = "fail"
+)
+
+// copyOptions are the knobs that change how copyRows writes to postgres.
+type copyOptions struct {
+	// bulk streams rows through COPY into a staging table instead of issuing
+	// one INSERT per row.
+	bulk bool
+
+	// onConflict is one of conflictSkip, conflictUpdate or conflictFail.
+	onConflict string
+
+	// after and limit restrict the copy to a range of sqlite rowids, limit 0
+	// means no limit.
+	after int64
+	limit int
+
+	// chunkSize is how many rows are read from sqlite at a time.
+	chunkSize int
 
-	rows, err := lite.Queryx(`SELECT * FROM ` + tableName)
+	// checkpoint makes copyRows extend chain with every row it reads.
+	checkpoint bool
+	chain      []byte
+
+	// deferred are the foreign keys copyRowsResumable has to defer in the
+	// transaction of each batch.
+	deferred []foreignKey
+}
+
+func copyRows(pgx *sqlx.Tx, t table, opts copyOptions) (stats tableStats, err error) {
+	tableName := t.name
+	stats.table = tableName
+	defer progressLine.clear()
+
+	columns, err := describeTable(pgx, tableName)
 	if err != nil {
-		fmt.Println("error selecting "+tableName, err)
-		return err
+		return stats, err
+	}
+
+	ncolumns := len(columns)
+	columnnames := make([]string, ncolumns)
+	valuelabels := make([]string, ncolumns)
+	values := make([]interface{}, ncolumns)
+	for i, col := range columns {
+		valuelabels[i] = fmt.Sprintf("$%d", i+1)
+		columnnames[i] = col.Name
+	}
+
+	skipStmt := ""
+	updateStmt := ""
+	if t.unique != "" {
+		skipStmt = `ON CONFLICT (` + t.unique + `) DO NOTHING`
+		updateStmt = conflictUpdateStmt(t, columnnames)
 	}
 
-	uniqueStmt := ""
-	if unique != "" {
-		uniqueStmt = `ON CONFLICT (` + unique + `) DO NOTHING`
+	// later batches of a resumable copy come after the delete
+	if replacedTables[t.name] && opts.after == 0 {
+		if _, err := pgx.Exec(`DELETE FROM ` + tableName); err != nil {
+			fmt.Println("error deleting "+tableName+" rows already on postgres", err)
+			return stats, err
+		}
 	}
 
-	for rows.Next() {
-		vpointer := reflect.New(typ).Interface()
-		err := rows.StructScan(vpointer)
+	var bulk *bulkCopy
+	if opts.bulk {
+		bulk, err = startBulk(pgx, tableName, columnnames)
 		if err != nil {
-			pretty.Log(vpointer)
-			fmt.Println("error scanning "+tableName+" row", err)
-			return err
+			return stats, err
 		}
+		defer bulk.close()
+	}
 
-		for i := 0; i < nfields; i++ {
-			values[i] = reflect.Indirect(reflect.ValueOf(vpointer)).Field(i).Interface()
+	insert := `
+INSERT INTO ` + tableName + ` (` + strings.Join(columnnames, ",") + `)
+VALUES (` + strings.Join(valuelabels, ",") + `)
+`
+
+	// the same scan targets are reused for every row
+	targets := make([]interface{}, ncolumns)
+	for i, col := range columns {
+		targets[i] = col.scanTarget()
+	}
+	scanArgs := append([]interface{}{&stats.lastRowid}, targets...)
+
+	copyRow := func() error {
+		for i := 0; i < ncolumns; i++ {
+			values[i] = reflect.Indirect(reflect.ValueOf(targets[i])).Interface()
+		}
+
+		if bulk != nil {
+			if _, err := bulk.stmt.Exec(values...); err != nil {
+				pretty.Log(rowDump(columns, targets))
+				fmt.Println("error streaming '" + tableName + "' row: " + err.Error())
+				return err
+			}
+			return nil
 		}
 
-		_, err = pgx.Exec(`
-INSERT INTO `+tableName+` (`+strings.Join(columnnames, ",")+`)
-VALUES (`+strings.Join(valuelabels, ",")+`)
-`+uniqueStmt,
-			values...)
+		result, err := pgx.Exec(insert+skipStmt, values...)
 		if err != nil {
-			pretty.Log(vpointer)
-			pretty.Log(err)
+			pretty.Log(rowDump(columns, targets))
+			if logSecrets {
+				// a *pq.Error's Detail and Where can quote the row's values
+				pretty.Log(err)
+			}
 			fmt.Println("error inserting on '" + tableName + "': " + err.Error())
 			return err
 		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected > 0 {
+			stats.written += int(affected)
+			return nil
+		}
+
+		// the row already exists on postgres
+		key := keyDump(t, columns, targets)
+		stats.conflicts = append(stats.conflicts, key)
+		switch opts.onConflict {
+		case conflictFail:
+			err = fmt.Errorf("%s row with %s already exists on postgres", tableName, key)
+			fmt.Println(err)
+			return err
+		case conflictUpdate:
+			if _, err := pgx.Exec(insert+updateStmt, values...); err != nil {
+				fmt.Println("error updating '" + tableName + "' row with " + key + ": " + err.Error())
+				return err
+			}
+			stats.updated++
+		default:
+			stats.skipped++
+		}
+		return nil
 	}
-	return nil
-}
 
-func setSequence(sequenceName string) (err error) {
-	parts := strings.Split(sequenceName, "_")
-	tableName := strings.Join(parts[0:len(parts)-2], "_")
-	column := parts[len(parts)-2]
+	// read sqlite in chunks of consecutive rowids so only one chunk is in
+	// flight at a time
+	chunkSize := opts.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	start := time.Now()
+	stats.lastRowid = opts.after
+	stats.chain = opts.chain
+	for {
+		chunk := nextChunk(chunkSize, opts.limit, stats.read)
+		if chunk == 0 {
+			break
+		}
+
+		rows, err := lite.Query(`SELECT rowid, `+strings.Join(columnnames, ",")+` FROM `+tableName+`
+WHERE rowid > ? ORDER BY rowid LIMIT ?`, stats.lastRowid, chunk)
+		if err != nil {
+			fmt.Println("error selecting "+tableName, err)
+			return stats, err
+		}
 
-	var maxval int
-	err = lite.Get(&maxval, `SELECT coalesce(max(`+column+`), 0) FROM `+tableName)
-	if err != nil {
-		fmt.Println("error fetching maximum value for", sequenceName, tableName, column, err)
-		return
+		n := 0
+		for rows.Next() {
+			if err := rows.Scan(scanArgs...); err != nil {
+				rows.Close()
+				pretty.Log(rowDump(columns, targets))
+				fmt.Println("error scanning "+tableName+" row", err)
+				return stats, err
+			}
+			n++
+			stats.read++
+			progressLine.row(tableName)
+			if opts.checkpoint {
+				stats.chain = chainHash(stats.chain, targets)
+			}
+
+			if err := copyRow(); err != nil {
+				rows.Close()
+				return stats, err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return stats, err
+		}
+
+		notePeakMemory()
+		if n < chunk {
+			break
+		}
 	}
 
-	if maxval == 0 {
-		// all is fine
-		return
+	if bulk != nil {
+		// see what already exists before merging
+		if t.unique != "" {
+			stats.conflicts, err = bulk.conflicts(t, columns)
+			if err != nil {
+				fmt.Println("error looking for conflicts on '" + tableName + "': " + err.Error())
+				return stats, err
+			}
+		}
+		if len(stats.conflicts) > 0 && opts.onConflict == conflictFail {
+			err = fmt.Errorf("%d %s rows already exist on postgres: %s",
+				len(stats.conflicts), tableName, strings.Join(stats.conflicts, "; "))
+			fmt.Println(err)
+			return stats, err
+		}
+
+		stmt := skipStmt
+		if opts.onConflict == conflictUpdate {
+			stmt = updateStmt
+			stats.updated = len(stats.conflicts)
+		}
+		affected, err := bulk.merge(stmt)
+		if err != nil {
+			fmt.Println("error merging staged rows into '" + tableName + "': " + err.Error())
+			return stats, err
+		}
+		stats.written = affected - stats.updated
+		stats.skipped = stats.read - affected
 	}
 
-	nextval := maxval + 1
-	_, err = pg.Exec(`SELECT setval('`+sequenceName+`', $1)`, nextval)
-	if err != nil {
-		fmt.Println("error setting sequence", sequenceName, err)
-		return
+	stats.duration = time.Since(start)
+	return stats, nil
+}
+
+// conflictUpdateStmt is the ON CONFLICT clause that overwrites the existing
+// row with the one from sqlite.
+func conflictUpdateStmt(t table, columnnames []string) string {
+	keys := make(map[string]bool)
+	for _, key := range t.keys() {
+		keys[key] = true
+	}
+
+	var sets []string
+	for _, name := range columnnames {
+		if !keys[name] {
+			sets = append(sets, name+"=EXCLUDED."+name)
+		}
 	}
+	if len(sets) == 0 {
+		return `ON CONFLICT (` + t.unique + `) DO NOTHING`
+	}
+
+	return `ON CONFLICT (` + t.unique + `) DO UPDATE SET ` + strings.Join(sets, ",")
+}
 
-	return nil
+// nextChunk is how many rows to ask sqlite for next, after reading some of a
+// copy limited to limit rows (0 meaning no limit).
+func nextChunk(chunkSize, limit, read int) int {
+	if limit > 0 && limit-read < chunkSize {
+		return limit - read
+	}
+	return chunkSize
 }
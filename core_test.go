@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestConflictUpdateStmt(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   table
+		columns []string
+		stmt    string
+	}{
+		{
+			name:    "single key",
+			table:   table{"peers", "id"},
+			columns: []string{"id", "node_id", "address"},
+			stmt:    "ON CONFLICT (id) DO UPDATE SET node_id=EXCLUDED.node_id,address=EXCLUDED.address",
+		},
+		{
+			name:    "composite key with spaces",
+			table:   table{"channel_feerates", "channel_id, hstate"},
+			columns: []string{"channel_id", "hstate", "feerate_per_kw"},
+			stmt:    "ON CONFLICT (channel_id, hstate) DO UPDATE SET feerate_per_kw=EXCLUDED.feerate_per_kw",
+		},
+		{
+			name:    "only key columns",
+			table:   table{"transaction_annotations", "txid, idx"},
+			columns: []string{"txid", "idx"},
+			stmt:    "ON CONFLICT (txid, idx) DO NOTHING",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := conflictUpdateStmt(tt.table, tt.columns); got != tt.stmt {
+				t.Errorf("got %s, want %s", got, tt.stmt)
+			}
+		})
+	}
+}
+
+func TestNextChunk(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize int
+		limit     int
+		read      int
+		chunk     int
+	}{
+		{"no limit", 100, 0, 0, 100},
+		{"no limit after some rows", 100, 0, 250, 100},
+		{"limit bigger than a chunk", 100, 250, 0, 100},
+		{"last chunk of a limit", 100, 250, 200, 50},
+		{"limit reached", 100, 250, 250, 0},
+		{"limit smaller than a chunk", 100, 10, 0, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextChunk(tt.chunkSize, tt.limit, tt.read); got != tt.chunk {
+				t.Errorf("got %d, want %d", got, tt.chunk)
+			}
+		})
+	}
+}
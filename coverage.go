@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+)
+
+// checkCoverage lists every sqlite table and tells whether it will be copied,
+// is intentionally skipped or is unknown to this release. It returns the names
+// of the unknown ones.
+func checkCoverage(rel *release) (unknown []string, err error) {
+	var names []string
+	err = lite.Select(&names, "SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	if err != nil {
+		fmt.Println("error listing sqlite tables", err)
+		return nil, err
+	}
+
+	status := make(map[string]string)
+	status["vars"] = "copied"
+	for _, t := range rel.tables {
+		status[t.name] = "copied"
+	}
+	for _, name := range rel.skip {
+		status[name] = "skipped"
+	}
+
+	fmt.Println("  > sqlite tables:")
+	for _, name := range names {
+		s, ok := status[name]
+		if !ok {
+			s = "UNKNOWN"
+			unknown = append(unknown, name)
+		}
+		fmt.Printf("      %-28s %s\n", name, s)
+	}
+
+	return unknown, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import "github.com/jmoiron/sqlx"
+
+// schemas has the postgres DDL for every db version whose schema mcldsp can
+// create by itself, without running lightningd.
+var schemas = map[int]string{
+	162: schema162,
+}
+
+func createSchema(version int) (err error) {
+	tx, err := pg.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createTables(tx, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// createTables runs the DDL for a db version in tx, so a dry run can create
+// the schema and roll it back with everything else.
+func createTables(tx *sqlx.Tx, version int) error {
+	_, err := tx.Exec(schemas[version])
+	return err
+}
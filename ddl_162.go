@@ -0,0 +1,341 @@
+package main
+
+// schema162 is the postgres schema lightningd's migrations leave behind at
+// database version 162.
+const schema162 = `
+CREATE TABLE version (version INTEGER);
+INSERT INTO version VALUES (162);
+
+CREATE TABLE db_upgrades (upgrade_from INTEGER, lightning_version TEXT);
+
+CREATE TABLE vars (
+  name VARCHAR(32),
+  val VARCHAR(255),
+  intval INTEGER,
+  blobval BYTEA,
+  PRIMARY KEY (name)
+);
+
+CREATE TABLE blocks (
+  height INTEGER,
+  hash BYTEA,
+  prev_hash BYTEA,
+  UNIQUE (height)
+);
+
+CREATE TABLE shachains (
+  id BIGSERIAL,
+  min_index BIGINT,
+  num_valid BIGINT,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE shachain_known (
+  shachain_id BIGINT REFERENCES shachains(id) ON DELETE CASCADE,
+  pos INTEGER,
+  idx BIGINT,
+  hash BYTEA,
+  PRIMARY KEY (shachain_id, pos)
+);
+
+CREATE TABLE peers (
+  id BIGSERIAL,
+  node_id BYTEA UNIQUE,
+  address TEXT,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE channel_configs (
+  id BIGSERIAL,
+  dust_limit_satoshis BIGINT,
+  max_htlc_value_in_flight_msat BIGINT,
+  channel_reserve_satoshis BIGINT,
+  htlc_minimum_msat BIGINT,
+  to_self_delay INTEGER,
+  max_accepted_htlcs INTEGER,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE channels (
+  id BIGSERIAL,
+  peer_id BIGINT REFERENCES peers(id) ON DELETE CASCADE,
+  short_channel_id TEXT,
+  channel_config_local BIGINT,
+  channel_config_remote BIGINT,
+  state INTEGER,
+  funder INTEGER,
+  channel_flags INTEGER,
+  minimum_depth INTEGER,
+  next_index_local BIGINT,
+  next_index_remote BIGINT,
+  next_htlc_id BIGINT,
+  funding_tx_id BYTEA,
+  funding_tx_outnum INTEGER,
+  funding_satoshi BIGINT,
+  funding_tx_remote_sigs_received INTEGER,
+  our_funding_satoshi BIGINT DEFAULT 0,
+  funding_locked_remote INTEGER,
+  push_msatoshi BIGINT,
+  msatoshi_local BIGINT,
+  fundingkey_remote BYTEA,
+  revocation_basepoint_remote BYTEA,
+  payment_basepoint_remote BYTEA,
+  htlc_basepoint_remote BYTEA,
+  delayed_payment_basepoint_remote BYTEA,
+  per_commit_remote BYTEA,
+  old_per_commit_remote BYTEA,
+  local_feerate_per_kw INTEGER,
+  remote_feerate_per_kw INTEGER,
+  shachain_remote_id BIGINT,
+  shutdown_scriptpubkey_remote BYTEA,
+  shutdown_keyidx_local BIGINT,
+  last_sent_commit_state BIGINT,
+  last_sent_commit_id INTEGER,
+  last_tx BYTEA,
+  last_sig BYTEA,
+  closing_fee_received INTEGER,
+  closing_sig_received BYTEA,
+  first_blocknum BIGINT,
+  last_was_revoke INTEGER,
+  in_payments_offered INTEGER DEFAULT 0,
+  in_payments_fulfilled INTEGER DEFAULT 0,
+  in_msatoshi_offered BIGINT DEFAULT 0,
+  in_msatoshi_fulfilled BIGINT DEFAULT 0,
+  out_payments_offered INTEGER DEFAULT 0,
+  out_payments_fulfilled INTEGER DEFAULT 0,
+  out_msatoshi_offered BIGINT DEFAULT 0,
+  out_msatoshi_fulfilled BIGINT DEFAULT 0,
+  min_possible_feerate INTEGER,
+  max_possible_feerate INTEGER,
+  msatoshi_to_us_min BIGINT,
+  msatoshi_to_us_max BIGINT,
+  future_per_commitment_point BYTEA,
+  last_sent_commit BYTEA,
+  feerate_base INTEGER,
+  feerate_ppm INTEGER,
+  remote_upfront_shutdown_script BYTEA,
+  remote_ann_node_sig BYTEA,
+  remote_ann_bitcoin_sig BYTEA,
+  option_static_remotekey INTEGER DEFAULT 0,
+  shutdown_scriptpubkey_local BYTEA,
+  option_anchor_outputs INTEGER DEFAULT 0,
+  full_channel_id BYTEA DEFAULT NULL,
+  funding_psbt BYTEA DEFAULT NULL,
+  closer INTEGER DEFAULT 2,
+  state_change_reason INTEGER DEFAULT 0,
+  revocation_basepoint_local BYTEA,
+  payment_basepoint_local BYTEA,
+  htlc_basepoint_local BYTEA,
+  delayed_payment_basepoint_local BYTEA,
+  funding_pubkey_local BYTEA,
+  shutdown_wrong_txid BYTEA DEFAULT NULL,
+  shutdown_wrong_outnum INTEGER DEFAULT NULL,
+  local_static_remotekey_start BIGINT DEFAULT 0,
+  remote_static_remotekey_start BIGINT DEFAULT 0,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE channel_feerates (
+  channel_id BIGINT REFERENCES channels(id) ON DELETE CASCADE,
+  hstate INTEGER,
+  feerate_per_kw INTEGER,
+  UNIQUE (channel_id, hstate)
+);
+
+CREATE TABLE channel_htlcs (
+  id BIGSERIAL,
+  channel_id BIGINT REFERENCES channels(id) ON DELETE CASCADE,
+  channel_htlc_id BIGINT,
+  direction INTEGER,
+  origin_htlc BIGINT,
+  msatoshi BIGINT,
+  cltv_expiry INTEGER,
+  payment_hash BYTEA,
+  payment_key BYTEA,
+  routing_onion BYTEA,
+  failuremsg BYTEA,
+  malformed_onion INTEGER,
+  hstate INTEGER,
+  shared_secret BYTEA,
+  received_time BIGINT,
+  localfailmsg BYTEA,
+  partid BIGINT,
+  we_filled INTEGER,
+  PRIMARY KEY (id),
+  UNIQUE (channel_id, channel_htlc_id, direction)
+);
+CREATE INDEX channel_htlcs_speedup_unresolved_idx ON channel_htlcs (channel_id, direction) WHERE hstate NOT IN (9, 19);
+
+CREATE TABLE htlc_sigs (
+  channelid INTEGER REFERENCES channels(id) ON DELETE CASCADE,
+  signature BYTEA
+);
+CREATE INDEX channel_idx ON htlc_sigs (channelid);
+
+CREATE TABLE transactions (
+  id BYTEA,
+  blockheight INTEGER REFERENCES blocks(height) ON DELETE SET NULL,
+  txindex INTEGER,
+  rawtx BYTEA,
+  type BIGINT,
+  channel_id BIGINT,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE transaction_annotations (
+  txid BYTEA,
+  idx INTEGER,
+  location INTEGER,
+  type INTEGER,
+  channel BIGINT REFERENCES channels(id),
+  UNIQUE (txid, idx)
+);
+
+CREATE TABLE channeltxs (
+  id BIGSERIAL,
+  channel_id BIGINT REFERENCES channels(id) ON DELETE CASCADE,
+  type INTEGER,
+  transaction_id BYTEA REFERENCES transactions(id) ON DELETE CASCADE,
+  input_num INTEGER,
+  blockheight INTEGER REFERENCES blocks(height) ON DELETE CASCADE,
+  PRIMARY KEY (id)
+);
+
+CREATE TABLE outputs (
+  prev_out_tx BYTEA,
+  prev_out_index INTEGER,
+  value BIGINT,
+  type INTEGER,
+  status INTEGER,
+  keyindex INTEGER,
+  channel_id BIGINT,
+  peer_id BYTEA,
+  commitment_point BYTEA,
+  confirmation_height INTEGER REFERENCES blocks(height) ON DELETE SET NULL,
+  spend_height INTEGER REFERENCES blocks(height) ON DELETE SET NULL,
+  scriptpubkey BYTEA,
+  reserved_til INTEGER DEFAULT NULL,
+  option_anchor_outputs INTEGER DEFAULT 0,
+  PRIMARY KEY (prev_out_tx, prev_out_index)
+);
+CREATE INDEX output_height_idx ON outputs (confirmation_height, spend_height);
+
+CREATE TABLE offers (
+  offer_id BYTEA,
+  bolt12 TEXT,
+  label TEXT,
+  status INTEGER,
+  PRIMARY KEY (offer_id)
+);
+
+CREATE TABLE payments (
+  id BIGSERIAL,
+  timestamp INTEGER,
+  status INTEGER,
+  payment_hash BYTEA,
+  destination BYTEA,
+  msatoshi BIGINT,
+  payment_preimage BYTEA,
+  path_secrets BYTEA,
+  route_nodes BYTEA,
+  route_channels BYTEA,
+  failonionreply BYTEA,
+  faildestperm INTEGER,
+  failindex INTEGER,
+  failcode INTEGER,
+  failnode BYTEA,
+  failchannel TEXT,
+  failupdate BYTEA,
+  msatoshi_sent BIGINT,
+  faildetail TEXT,
+  description TEXT,
+  faildirection INTEGER,
+  bolt11 TEXT,
+  total_msat BIGINT,
+  partid BIGINT,
+  local_offer_id BYTEA DEFAULT NULL REFERENCES offers(offer_id),
+  PRIMARY KEY (id),
+  UNIQUE (payment_hash, partid)
+);
+CREATE INDEX payments_idx ON payments (payment_hash);
+
+CREATE TABLE invoices (
+  id BIGSERIAL,
+  state INTEGER,
+  msatoshi BIGINT,
+  payment_hash BYTEA,
+  payment_key BYTEA,
+  label TEXT,
+  expiry_time BIGINT,
+  pay_index BIGINT,
+  msatoshi_received BIGINT,
+  paid_timestamp BIGINT,
+  bolt11 TEXT,
+  description TEXT,
+  features BYTEA DEFAULT '',
+  local_offer_id BYTEA DEFAULT NULL REFERENCES offers(offer_id),
+  PRIMARY KEY (id),
+  UNIQUE (label),
+  UNIQUE (payment_hash),
+  UNIQUE (pay_index)
+);
+
+CREATE TABLE forwarded_payments (
+  in_htlc_id BIGINT REFERENCES channel_htlcs(id) ON DELETE SET NULL,
+  out_htlc_id BIGINT REFERENCES channel_htlcs(id) ON DELETE SET NULL,
+  in_channel_scid BIGINT,
+  out_channel_scid BIGINT,
+  in_msatoshi BIGINT,
+  out_msatoshi BIGINT,
+  state INTEGER,
+  received_time BIGINT,
+  resolved_time BIGINT,
+  failcode INTEGER,
+  UNIQUE (in_htlc_id, out_htlc_id)
+);
+
+CREATE TABLE utxoset (
+  txid BYTEA NOT NULL,
+  outnum INTEGER NOT NULL,
+  blockheight INTEGER REFERENCES blocks(height) ON DELETE CASCADE,
+  spendheight INTEGER REFERENCES blocks(height) ON DELETE SET NULL,
+  txindex INTEGER,
+  scriptpubkey BYTEA,
+  satoshis BIGINT,
+  PRIMARY KEY (txid, outnum)
+);
+CREATE INDEX short_channel_id ON utxoset (blockheight, txindex, outnum);
+CREATE INDEX utxoset_spend ON utxoset (spendheight);
+
+CREATE TABLE penalty_bases (
+  channel_id BIGINT REFERENCES channels(id) ON DELETE CASCADE,
+  commitnum BIGINT,
+  txid BYTEA,
+  outnum INTEGER,
+  amount BIGINT,
+  PRIMARY KEY (channel_id, commitnum)
+);
+
+CREATE TABLE channel_state_changes (
+  channel_id BIGINT REFERENCES channels(id) ON DELETE CASCADE,
+  timestamp BIGINT,
+  old_state INTEGER,
+  new_state INTEGER,
+  cause INTEGER,
+  message TEXT
+);
+
+CREATE TABLE channel_funding_inflights (
+  channel_id BIGSERIAL REFERENCES channels(id) ON DELETE CASCADE,
+  funding_tx_id BYTEA,
+  funding_tx_outnum INTEGER,
+  funding_feerate INTEGER,
+  funding_satoshi BIGINT,
+  our_funding_satoshi BIGINT,
+  funding_psbt BYTEA,
+  last_tx BYTEA,
+  last_sig BYTEA,
+  funding_tx_remote_sigs_received INTEGER,
+  PRIMARY KEY (channel_id, funding_tx_id)
+);
+`
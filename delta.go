@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// a -snapshot run leaves the chainHash of every table it copied in
+// mcldsp_snapshot. the run that follows with lightningd stopped hashes sqlite
+// again and copies only the tables that changed since, deleting what the
+// snapshot had put there first.
+
+func saveSnapshotHashes(pgx *sqlx.Tx, hashes map[string]string) error {
+	_, err := pgx.Exec(`
+CREATE TABLE IF NOT EXISTS mcldsp_snapshot (
+  table_name TEXT PRIMARY KEY,
+  source_hash TEXT NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT now()
+)
+    `)
+	if err != nil {
+		fmt.Println("error creating snapshot table", err)
+		return err
+	}
+	if _, err := pgx.Exec(`DELETE FROM mcldsp_snapshot`); err != nil {
+		fmt.Println("error clearing snapshot table", err)
+		return err
+	}
+	for name, hash := range hashes {
+		_, err := pgx.Exec(`INSERT INTO mcldsp_snapshot (table_name, source_hash) VALUES ($1, $2)`, name, hash)
+		if err != nil {
+			fmt.Println("error saving snapshot hash for "+name, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSnapshotHashes returns nil if there was no -snapshot run before.
+func loadSnapshotHashes(pgx *sqlx.Tx) (map[string]string, error) {
+	var exists bool
+	err := pgx.Get(&exists, `SELECT to_regclass('public.mcldsp_snapshot') IS NOT NULL`)
+	if err != nil {
+		fmt.Println("error looking for snapshot table", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	var list []struct {
+		Table string `db:"table_name"`
+		Hash  string `db:"source_hash"`
+	}
+	if err := pgx.Select(&list, `SELECT table_name, source_hash FROM mcldsp_snapshot`); err != nil {
+		fmt.Println("error loading snapshot hashes", err)
+		return nil, err
+	}
+
+	hashes := make(map[string]string, len(list))
+	for _, h := range list {
+		hashes[h.Table] = h.Hash
+	}
+	return hashes, nil
+}
+
+// statsHashes takes the hashes copyRows computed with opts.checkpoint.
+func statsHashes(stats []tableStats) map[string]string {
+	hashes := make(map[string]string, len(stats))
+	for _, s := range stats {
+		if s.table != "vars" {
+			hashes[s.table] = hex.EncodeToString(s.chain)
+		}
+	}
+	return hashes
+}
+
+// changedTables hashes every table on sqlite and returns, in the given order,
+// the ones that are not as the snapshot left them, together with the hashes
+// of all of them.
+func changedTables(tables []table, snapshot map[string]string, fks []foreignKey) (
+	changed []table, current map[string]string, err error,
+) {
+	current = make(map[string]string, len(tables))
+	names := make(map[string]bool)
+	for _, t := range tables {
+		hash, err := sourceHash(t.name, math.MaxInt64)
+		if err != nil {
+			return nil, nil, err
+		}
+		current[t.name] = hash
+		if previous, ok := snapshot[t.name]; !ok || previous != hash {
+			names[t.name] = true
+		}
+	}
+
+	for _, t := range tables {
+		if referencesAny(t.name, names, fks) {
+			changed = append(changed, t)
+		}
+	}
+	return changed, current, nil
+}
+
+// referencesAny tells if a table is in names or references one of them,
+// directly or not. those must all be copied again, as deleting the rows of a
+// table cascades to the ones referencing it.
+func referencesAny(tableName string, names map[string]bool, fks []foreignKey) bool {
+	seen := make(map[string]bool)
+	next := []string{tableName}
+	for len(next) > 0 {
+		name := next[len(next)-1]
+		next = next[:len(next)-1]
+		if names[name] {
+			return true
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		for _, fk := range fks {
+			if fk.Table == name {
+				next = append(next, fk.References)
+			}
+		}
+	}
+	return false
+}
+
+// clearTables deletes every row of the given tables, the ones referencing
+// others first.
+func clearTables(pgx *sqlx.Tx, tables []table) error {
+	for i := len(tables) - 1; i >= 0; i-- {
+		if _, err := pgx.Exec(`DELETE FROM ` + tables[i].name); err != nil {
+			fmt.Println("error deleting "+tables[i].name+" rows", err)
+			return err
+		}
+	}
+	return nil
+}
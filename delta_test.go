@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestReferencesAny(t *testing.T) {
+	fks := parseSchema(schema162).foreignKeys
+
+	tests := []struct {
+		table   string
+		changed []string
+		want    bool
+	}{
+		{"blocks", []string{"blocks"}, true},
+		{"channels", []string{"peers"}, true},
+		{"forwarded_payments", []string{"peers"}, true}, // through channel_htlcs and channels
+		{"htlc_sigs", []string{"channels"}, true},
+		{"channels", []string{"channel_htlcs"}, false},
+		{"peers", []string{"channels"}, false},
+		{"shachain_known", []string{"blocks", "channels"}, false},
+		{"invoices", nil, false},
+	}
+
+	for _, tt := range tests {
+		names := make(map[string]bool)
+		for _, name := range tt.changed {
+			names[name] = true
+		}
+		if got := referencesAny(tt.table, names, fks); got != tt.want {
+			t.Errorf("referencesAny(%s, %v) = %v, want %v", tt.table, tt.changed, got, tt.want)
+		}
+	}
+}
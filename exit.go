@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// exit codes, one for each way mcldsp can fail, so scripts can tell a
+// migration that didn't happen from one that did. 2 is left out, it is what Go
+// exits with on a panic.
+const (
+	exitOK             = 0
+	exitFailure        = 1 // anything not covered below
+	exitConnection     = 3
+	exitVersion        = 4
+	exitSchema         = 5
+	exitHtlcSigs       = 6
+	exitCopy           = 7
+	exitSequence       = 8
+	exitCommit         = 9
+	exitSource         = 10 // the sqlite file can't be read, checked or backed up
+	exitNodeRunning    = 11
+	exitVerifyMismatch = 12
+	exitUsage          = 64 // EX_USAGE from sysexits.h
+)
+
+var failureClasses = map[int]string{
+	exitFailure:        "failure",
+	exitUsage:          "usage",
+	exitConnection:     "connection",
+	exitVersion:        "version",
+	exitSchema:         "schema",
+	exitHtlcSigs:       "htlc_sigs",
+	exitCopy:           "copy",
+	exitSequence:       "sequence",
+	exitCommit:         "commit",
+	exitSource:         "source",
+	exitNodeRunning:    "node-running",
+	exitVerifyMismatch: "verify-mismatch",
+}
+
+// errSequence marks errors that happened while setting sequences, for the
+// code that only gets an error back from copying a whole group of tables.
+var errSequence = errors.New("sequence")
+
+// errSource marks errors reading sqlite from code that reads postgres too.
+var errSource = errors.New("sqlite")
+
+// fail prints why mcldsp is giving up, records it in the report and returns
+// the exit code for it.
+func fail(code int, reason string) int {
+	progressLine.clear()
+	fmt.Printf("failed (%s): %s\n", failureClasses[code], reason)
+	report.Failure = failureClasses[code]
+	report.Error = reason
+	report.ExitCode = code
+	return code
+}
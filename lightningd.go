@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// createSchemaWithLightningd runs lightningd against the postgres database and
+// waits until its migrations have created the schema, then stops it.
+func createSchemaWithLightningd(lightningd string, postgres string, expectedVersion int, timeout time.Duration) error {
+	cmd := exec.Command(lightningd,
+		"--lightning-dir=/tmp/mcldsp-lightning",
+		"--network=regtest",
+		"--wallet="+postgres,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start lightningd: %w", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			// it may have created the schema and only then died, for example
+			// for lack of a bitcoind, so look once more
+			if dbversion, ok := createdVersion(); ok {
+				return checkCreatedVersion(dbversion, expectedVersion)
+			}
+			return fmt.Errorf("lightningd exited before the schema was created: %v", err)
+		case <-deadline:
+			stopProcess(cmd, exited)
+			return fmt.Errorf("lightningd didn't create the schema in %s", timeout)
+		case <-ticker.C:
+			dbversion, ok := createdVersion()
+			if !ok {
+				continue
+			}
+			stopProcess(cmd, exited)
+			return checkCreatedVersion(dbversion, expectedVersion)
+		}
+	}
+}
+
+// createdVersion tells if the schema is there. lightningd applies all its
+// migrations in a single transaction, so once there is a version the schema
+// is complete.
+func createdVersion() (int, bool) {
+	var dbversion int
+	if err := pg.Get(&dbversion, "SELECT version FROM version"); err != nil {
+		return 0, false
+	}
+	return dbversion, true
+}
+
+func checkCreatedVersion(dbversion, expectedVersion int) error {
+	if dbversion != expectedVersion {
+		return fmt.Errorf("lightningd created schema version %d, but sqlite is at %d", dbversion, expectedVersion)
+	}
+	return nil
+}
+
+// stopProcess asks the process to terminate and kills it if it doesn't.
+func stopProcess(cmd *exec.Cmd, exited chan error) {
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-exited:
+	case <-time.After(time.Second * 10):
+		cmd.Process.Kill()
+		<-exited
+	}
+}
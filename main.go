@@ -1,11 +1,10 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
 
@@ -14,14 +13,18 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const version = 162
 const USAGE = `
 mcldsp
 
-Migrate your c-lightning database from SQLite to Postgres
+Migrate your c-lightning database from SQLite to Postgres (or back)
 
 Usage:
-  mcldsp -sqlite=<sqlite_file> -postgres=<postgres_dsn> -lightningd=<lightningd_executable>
+  mcldsp -sqlite=<sqlite_file> -postgres=<postgres_dsn> [-lightningd=<lightningd_executable>]
+  mcldsp -snapshot -sqlite=<sqlite_file> -postgres=<postgres_dsn>
+  mcldsp -dry-run -sqlite=<sqlite_file> -postgres=<postgres_dsn>
+  mcldsp -output=<script_file> -sqlite=<sqlite_file>
+  mcldsp -verify -sqlite=<sqlite_file> -postgres=<postgres_dsn>
+  mcldsp -direction=pg-to-sqlite -sqlite=<new_sqlite_file> -postgres=<postgres_dsn>
 `
 
 var sqlt *sqlx.DB
@@ -30,50 +33,197 @@ var pg *sqlx.DB
 var err error
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	// bad flags must not exit with 2 like flag.ExitOnError does, see exitUsage
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	sqlite := flag.String("sqlite", "", "Path to the lightningd.sqlite3 file.")
 	postgres := flag.String("postgres", "", "Postgres address like postgres://...")
-	lightningd := flag.String("lightningd", "", "Path to the lightningd executable.")
-	flag.Parse()
-
-	if *sqlite == "" || *postgres == "" || *lightningd == "" {
+	lightningd := flag.String("lightningd", "", "Path to the lightningd executable, only needed to create the postgres schema for db versions mcldsp doesn't know.")
+	lightningdTimeout := flag.Duration("lightningd-timeout", time.Minute*2, "How long to wait for lightningd to create the postgres schema.")
+	verify := flag.Bool("verify", false, "Don't migrate anything, just compare the data already on postgres with sqlite.")
+	dryRun := flag.Bool("dry-run", false, "Do the whole migration inside the postgres transaction, then roll it back instead of committing.")
+	rowByRow := flag.Bool("row-by-row", false, "Insert rows one at a time instead of streaming them with COPY (always the case on CockroachDB).")
+	onConflict := flag.String("on-conflict", conflictSkip, "What to do with sqlite rows whose key already exists on postgres: skip, update or fail.")
+	resume := flag.Bool("resume", false, "Commit every table separately and keep track of progress in a mcldsp_checkpoint table, so a failed migration can be continued by running the same command again.")
+	checkpointRows := flag.Int("checkpoint-rows", 0, "With -resume, commit every N rows instead of every table.")
+	chunkSize := flag.Int("chunk-size", defaultChunkSize, "How many rows to read from sqlite at a time, memory use grows with it.")
+	jobs := flag.Int("jobs", 1, "Copy tables that don't reference each other concurrently over this many postgres connections (needs max_prepared_transactions on postgres).")
+	allowUnknownTables := flag.Bool("allow-unknown-tables", false, "Migrate even if sqlite has tables this version of mcldsp doesn't know about (they will be left empty).")
+	snapshot := flag.Bool("snapshot", false, "Migrate from a consistent copy of the sqlite database taken with the online backup API, so lightningd can keep running meanwhile.")
+	output := flag.String("output", "", "Don't connect to postgres, write a SQL script with the whole migration to this file instead.")
+	unsafeLogSecrets := flag.Bool("unsafe-log-secrets", false, "Print keys, preimages and signatures in full when showing a row that failed, instead of masking them. Only for debugging.")
+	reportPath := flag.String("report", "", "Write a JSON report of the migration to this file.")
+	force := flag.Bool("force", false, "Migrate even if lightningd seems to be running.")
+	direction := flag.String("direction", "sqlite-to-pg", "sqlite-to-pg or pg-to-sqlite.")
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		return exitOK
+	} else if err != nil {
+		return exitUsage
+	}
+	logSecrets = *unsafeLogSecrets
+
+	if *sqlite == "" || (*postgres == "" && *output == "") {
 		fmt.Println(strings.TrimSpace(USAGE))
-		return
+		return exitUsage
+	}
+	if *direction == "pg-to-sqlite" {
+		if *verify || *dryRun || *resume || *snapshot || *reportPath != "" {
+			return fail(exitUsage, "-verify, -dry-run, -resume, -snapshot and -report are only supported from sqlite to postgres.")
+		}
+		return migrateBack(*sqlite, *postgres)
+	}
+	if *direction != "sqlite-to-pg" {
+		return fail(exitUsage, "-direction must be sqlite-to-pg or pg-to-sqlite.")
+	}
+	if *onConflict != conflictSkip && *onConflict != conflictUpdate && *onConflict != conflictFail {
+		return fail(exitUsage, "-on-conflict must be one of skip, update or fail.")
+	}
+	if *jobs > 1 && *resume {
+		return fail(exitUsage, "-jobs commits everything at once, it can't be used with -resume.")
+	}
+	if *output != "" && (*verify || *dryRun || *resume || *jobs > 1) {
+		return fail(exitUsage, "-output only writes a script, it can't be used with -verify, -dry-run, -resume or -jobs.")
+	}
+	if *resume && *snapshot {
+		return fail(exitUsage, "-snapshot takes a new copy every time, it can't be used with -resume.")
+	}
+	if *resume && *dryRun {
+		return fail(exitUsage, "-resume commits as it goes, it can't be used with -dry-run.")
+	}
+	if *jobs > 1 && *dryRun {
+		return fail(exitUsage, "-jobs drops foreign keys outside the transaction, it can't be used with -dry-run.")
+	}
+
+	if *reportPath != "" {
+		defer report.write(*reportPath)
+	}
+	report.Source.Path = *sqlite
+
+	if !*snapshot {
+		// a copy taken while lightningd writes is stale as soon as it's done
+		if signs := lightningdRunning(*sqlite); len(signs) > 0 {
+			fmt.Println("lightningd seems to be running:")
+			for _, sign := range signs {
+				fmt.Println("  - " + sign)
+			}
+			if !*force {
+				return fail(exitNodeRunning, "stop it first, use -snapshot to copy while it runs, or -force if you're sure it isn't.")
+			}
+			warn("lightningd seems to be running, going on anyway because of -force.")
+		}
+	}
+
+	// with -snapshot the backup is the snapshot: it is taken with the online
+	// backup API, checked and migrated from, so the live file is read only once
+	var backupPath string
+	if *snapshot {
+		fmt.Println("  > taking a snapshot of " + *sqlite + ".")
+		var sum string
+		var dataVersion int64
+		backupPath, sum, dataVersion, err = backupBeside(*sqlite)
+		if err != nil {
+			return fail(exitSource, "error taking sqlite snapshot: "+err.Error())
+		}
+		fmt.Printf("  > snapshot written to %s (sha256 %s) at data_version %d, migrating from it.\n", backupPath, sum, dataVersion)
+		report.Source.Backup = backupPath
+		report.Source.BackupSHA256 = sum
+		report.Source.Snapshot = true
+		report.Source.SnapshotDataVersion = dataVersion
+		*sqlite = backupPath
+	}
+
+	fmt.Println("  > checking the sqlite database.")
+	if err := checkSQLite(*sqlite); err != nil {
+		return fail(exitSource, "sqlite database is not sound, fix it before migrating: "+err.Error())
+	}
+
+	if !*snapshot && !*verify && !*dryRun {
+		var sum string
+		backupPath, sum, _, err = backupBeside(*sqlite)
+		if err != nil {
+			return fail(exitSource, "error backing up the sqlite database: "+err.Error())
+		}
+		fmt.Printf("  > sqlite database backed up to %s (sha256 %s).\n", backupPath, sum)
+		report.Source.Backup = backupPath
+		report.Source.BackupSHA256 = sum
+	}
+
+	if *output != "" {
+		code := writeScript(*sqlite, *output, *onConflict, *allowUnknownTables)
+		if backupPath != "" {
+			fmt.Println("  > the sqlite database was backed up to " + backupPath + ".")
+		}
+		return code
 	}
 
 	fmt.Println("  > connecting to sqlite and postgres.")
 
 	sqlt, err = sqlx.Connect("sqlite3", *sqlite)
 	if err != nil {
-		fmt.Println("sqlite connection error", err)
-		return
+		return fail(exitConnection, "sqlite connection error: "+err.Error())
+	}
+	lite, err = sqlt.Beginx()
+	if err != nil {
+		return fail(exitConnection, "sqlite transaction error: "+err.Error())
 	}
-	lite = sqlt.MustBegin()
 	defer lite.Rollback()
 
 	pg, err = sqlx.Connect("postgres", *postgres)
 	if err != nil {
-		fmt.Println("postgres connection error", err)
-		return
+		return fail(exitConnection, "postgres connection error: "+err.Error())
+	}
+
+	// a dry run starts the big transaction here, so even the schema it may
+	// create is rolled back
+	var pgx *sqlx.Tx
+	var pgq sqlx.Queryer = pg
+	if *dryRun {
+		pgx, err = pg.Beginx()
+		if err != nil {
+			return fail(exitConnection, err.Error())
+		}
+		defer pgx.Rollback()
+		pgq = pgx
 	}
 
 	// check if database structure is in place
 	var tablecount int
-	err = pg.Get(&tablecount, "SELECT count(*) FROM information_schema.tables WHERE table_schema = 'public'")
-	if tablecount == 0 {
-		fmt.Println("  > starting lightningd so it will create the needed postgres tables.")
-
+	err = sqlx.Get(pgq, &tablecount, "SELECT count(*) FROM information_schema.tables WHERE table_schema = 'public'")
+	if err != nil {
+		return fail(exitConnection, "error counting postgres tables: "+err.Error())
+	}
+	if tablecount == 0 && *verify {
+		return fail(exitSchema, "postgres database is empty, nothing to verify.")
+	} else if tablecount == 0 {
 		// if not, create database structure
-		lightningd := *lightningd
-		cmd := exec.Command(lightningd,
-			"--lightning-dir=/tmp/mcldsp-lightning",
-			"--network=regtest",
-			"--wallet="+*postgres,
-		)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Start()
-		time.Sleep(time.Second * 35)
-		cmd.Process.Kill()
+		var expectedVersion int
+		if err := lite.Get(&expectedVersion, "SELECT version FROM version"); err != nil {
+			return fail(exitVersion, "error fetching sqlite db version: "+err.Error())
+		}
+
+		if _, ok := schemas[expectedVersion]; ok && *dryRun {
+			fmt.Printf("  > creating the postgres tables for db version %d, they are rolled back with the rest.\n", expectedVersion)
+			if err := createTables(pgx, expectedVersion); err != nil {
+				return fail(exitSchema, "error creating database schema: "+err.Error())
+			}
+		} else if ok {
+			fmt.Printf("  > creating the postgres tables for db version %d.\n", expectedVersion)
+			if err := createSchema(expectedVersion); err != nil {
+				return fail(exitSchema, "error creating database schema: "+err.Error())
+			}
+		} else if *dryRun {
+			return fail(exitSchema, fmt.Sprintf("postgres database schema is missing and mcldsp doesn't know the one for db version %d, a dry run can't have lightningd create it.", expectedVersion))
+		} else if *lightningd != "" {
+			fmt.Println("  > starting lightningd so it will create the needed postgres tables.")
+			if err := createSchemaWithLightningd(*lightningd, *postgres, expectedVersion, *lightningdTimeout); err != nil {
+				return fail(exitSchema, "error creating database schema: "+err.Error())
+			}
+		} else {
+			return fail(exitSchema, fmt.Sprintf("mcldsp doesn't know the schema for db version %d, use -lightningd so lightningd can create it.", expectedVersion))
+		}
 
 		fmt.Println("  > database schema created.")
 	} else {
@@ -83,19 +233,30 @@ func main() {
 	// check tables are created
 	var expectedTableCount int
 	var createdTableCount int
-	lite.Get(&expectedTableCount, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name != 'android_metadata' AND name != 'sqlite_sequence'")
-	pg.Get(&createdTableCount, "SELECT count(*) FROM information_schema.tables WHERE table_schema = 'public'")
+	err = lite.Get(&expectedTableCount, "SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name != 'android_metadata' AND name != 'sqlite_sequence'")
+	if err != nil {
+		return fail(exitSource, "error counting sqlite tables: "+err.Error())
+	}
+	err = sqlx.Get(pgq, &createdTableCount, "SELECT count(*) FROM information_schema.tables WHERE table_schema = 'public' AND table_name NOT IN ('mcldsp_checkpoint', 'mcldsp_snapshot', 'mcldsp_foreign_keys')")
+	if err != nil {
+		return fail(exitConnection, "error counting postgres tables: "+err.Error())
+	}
 	if expectedTableCount != createdTableCount || createdTableCount < 18 {
-		fmt.Printf("postgres database structure wasn't created correctly: %v (expected %d tables to be created, got %d)\n", err, expectedTableCount, createdTableCount)
-		return
+		return fail(exitSchema, fmt.Sprintf("postgres database structure wasn't created correctly (expected %d tables to be created, got %d)", expectedTableCount, createdTableCount))
+	}
+
+	// a -jobs run that died may have left foreign keys dropped
+	if err := addForeignKeys(); err != nil {
+		return fail(exitSchema, "error adding back the foreign keys a previous run dropped: "+err.Error())
 	}
 
-	// check htlc_sigs is empty
+	// pending htlcs have signatures in htlc_sigs
 	var chtlcsigns int
-	err = lite.Get(&chtlcsigns, "SELECT count(*) FROM htlc_sigs")
-	if err != nil || chtlcsigns != 0 {
-		fmt.Println("htlc_sigs table is not empty", err)
-		return
+	if err := lite.Get(&chtlcsigns, "SELECT count(*) FROM htlc_sigs"); err != nil {
+		return fail(exitSource, "error counting htlc_sigs: "+err.Error())
+	}
+	if chtlcsigns != 0 {
+		warn(fmt.Sprintf("htlc_sigs has %d signatures of pending htlcs, they will be copied too.", chtlcsigns))
 	}
 
 	// check version
@@ -104,429 +265,393 @@ func main() {
 	var dbversionlite int
 	var dbversionpg int
 	err1 := lite.Get(&dbversionlite, "SELECT version FROM version")
-	err2 := pg.Get(&dbversionpg, "SELECT version FROM version")
+	err2 := sqlx.Get(pgq, &dbversionpg, "SELECT version FROM version")
 	if err1 != nil || err2 != nil {
-		fmt.Println("error fetching db versions", err)
-		return
+		return fail(exitVersion, fmt.Sprintf("error fetching db versions: sqlite: %v, postgres: %v", err1, err2))
+	}
+	report.Source.Version = dbversionlite
+	report.Target.Version = dbversionpg
+	if dbversionlite != dbversionpg {
+		return fail(exitVersion, fmt.Sprintf("db versions mismatch. got sqlite:%d, postgres:%d", dbversionlite, dbversionpg))
 	}
-	if dbversionlite != dbversionpg || dbversionpg != version {
-		fmt.Printf("db versions mismatch. expected %d, got sqlite:%d, postgres:%d\n", version, dbversionlite, dbversionpg)
-		return
+	rel := releaseFor(dbversionlite)
+	if rel == nil {
+		return fail(exitVersion, fmt.Sprintf("db version %d is not supported. supported versions: %s", dbversionlite, supportedVersions()))
+	}
+
+	// check we know what to do with every table
+	unknown, err := checkCoverage(rel)
+	if err != nil {
+		return fail(exitSource, "error listing sqlite tables: "+err.Error())
+	}
+	if len(unknown) > 0 {
+		if !*allowUnknownTables {
+			return fail(exitVersion, "sqlite has tables mcldsp doesn't know how to copy: "+strings.Join(unknown, ", ")+" (use -allow-unknown-tables to leave them empty)")
+		}
+		warn("WARNING: leaving unknown tables empty on postgres: " + strings.Join(unknown, ", "))
+	}
+
+	// apply the fixes for this version on sqlite, they won't be committed there
+	for _, f := range rel.fixes {
+		result, err := lite.Exec(f.query)
+		if err != nil {
+			return fail(exitSource, "error applying fix '"+f.description+"': "+err.Error())
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			warn(fmt.Sprintf("%s (%d rows)", f.description, affected))
+		}
+	}
+
+	if *verify {
+		pgx, err := pg.Beginx()
+		if err != nil {
+			return fail(exitConnection, err.Error())
+		}
+		defer pgx.Rollback()
+
+		ok, err := verifyTables(pgx, append([]table{{"vars", "name"}}, rel.tables...))
+		if err != nil {
+			return fail(exitFailure, "error verifying: "+err.Error())
+		}
+		sigsOk, err := checkHtlcSigs(pgx)
+		if errors.Is(err, errSource) {
+			return fail(exitSource, "error checking htlc_sigs: "+err.Error())
+		} else if err != nil {
+			return fail(exitConnection, "error checking htlc_sigs: "+err.Error())
+		}
+		if !sigsOk {
+			ok = false
+		}
+		if !ok {
+			report.Outcome = outcomeVerifyMismatch
+			return fail(exitVerifyMismatch, "postgres data DOES NOT match sqlite.")
+		}
+		report.Outcome = outcomeVerifyMatch
+		fmt.Println("  > postgres data matches sqlite.")
+		return exitOK
 	}
 
 	// start updating on a big transaction
 	fmt.Println("  > moving data from sqlite to postgres in a big db transaction.")
 
-	pgx, err := pg.Beginx()
-	if err != nil {
-		fmt.Println(err)
-		return
+	if pgx == nil {
+		pgx, err = pg.Beginx()
+		if err != nil {
+			return fail(exitConnection, err.Error())
+		}
+		defer pgx.Rollback()
 	}
-	defer pgx.Rollback()
 
-	// update vars
-	var vars []struct {
-		Name    sql.NullString `db:"name"`
-		Val     sql.NullString `db:"val"`
-		Intval  sql.NullInt64  `db:"intval"`
-		Blobval sqlblob        `db:"blobval"`
+	// COPY into staging tables is not something cockroach handles well, and its
+	// ids work differently too
+	var version string
+	if err := pg.Get(&version, "SELECT version()"); err != nil {
+		return fail(exitConnection, "failed to get database version: "+err.Error())
 	}
-	err = lite.Select(&vars, `SELECT * FROM vars`)
-	if err != nil {
-		fmt.Println("error selecting vars", err)
-		return
+	cockroach := strings.Index(version, "CockroachDB") != -1
+	report.Target.Backend = "postgres"
+	if cockroach {
+		report.Target.Backend = "cockroachdb"
+	}
+	if cockroach && *jobs > 1 {
+		return fail(exitUsage, "cockroach doesn't support PREPARE TRANSACTION, -jobs can't be used.")
 	}
-	for _, v := range vars {
-		if v.Name.String == "genesis_hash" {
-			// apparently old versions stored a blob in the 'val' column, but this is
-			// no longer needed nor supported in postgres.
-			v.Val = sql.NullString{Valid: false}
+	opts := copyOptions{bulk: !*rowByRow && !cockroach, onConflict: *onConflict, chunkSize: *chunkSize}
+
+	var checkpoints map[string]checkpoint
+	if *resume {
+		fmt.Println("  > committing each table separately, progress is saved in mcldsp_checkpoint.")
+		checkpoints, err = loadCheckpoints()
+		if err != nil {
+			return fail(exitCopy, "error loading checkpoints: "+err.Error())
 		}
+	}
 
-		_, err := pgx.NamedExec(`
-INSERT INTO vars
-VALUES (:name, :val, :intval, :blobval)
-ON CONFLICT (name) DO UPDATE SET val=:val, intval=:intval, blobval=:blobval
-            `, v)
+	// copy tables after the ones they reference
+	fks, err := loadForeignKeys(pgx)
+	if err != nil {
+		return fail(exitSchema, "error reading foreign keys: "+err.Error())
+	}
+	tables, deferred := sortTables(rel.tables, fks)
+
+	// after a -snapshot run only what changed since has to be copied
+	snapshotHashes, err := loadSnapshotHashes(pgx)
+	if err != nil {
+		return fail(exitCopy, "error loading snapshot hashes: "+err.Error())
+	}
+	var currentHashes map[string]string
+	if snapshotHashes != nil {
+		if *resume || *jobs > 1 {
+			return fail(exitUsage, "a -snapshot run was migrated before, what changed since can't be copied with -resume or -jobs.")
+		}
+		fmt.Println("  > comparing sqlite with what the -snapshot run copied.")
+		tables, currentHashes, err = changedTables(tables, snapshotHashes, fks)
 		if err != nil {
-			fmt.Println(v, "error inserting var", err)
-			return
-		}
-	}
-
-	// fix sqlite's invoices.features if there are wrong fields
-	lite.Exec(`UPDATE invoices SET features = '' WHERE length(features) = 0`)
-
-	// update all the other tables except version and db_upgrades
-	if err := copyRows(pgx, "blocks", struct {
-		Height   sql.NullInt64 `db:"height"`
-		Hash     sqlblob       `db:"hash"`
-		PrevHash sqlblob       `db:"prev_hash"`
-	}{}, "height"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "channel_configs", struct {
-		Id                   sql.NullInt64 `db:"id"`
-		DustLimit            sql.NullInt64 `db:"dust_limit_satoshis"`
-		MaxHTLCValueInFlight sql.NullInt64 `db:"max_htlc_value_in_flight_msat"`
-		ChannelReserve       sql.NullInt64 `db:"channel_reserve_satoshis"`
-		HTLCMinimum          sql.NullInt64 `db:"htlc_minimum_msat"`
-		ToSelfDelay          sql.NullInt64 `db:"to_self_delay"`
-		MaxAcceptedHTLCs     sql.NullInt64 `db:"max_accepted_htlcs"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "peers", struct {
-		Id      int64   `db:"id"`
-		NodeId  sqlblob `db:"node_id"`
-		Address string  `db:"address"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "channels", struct {
-		Id                            int64          `db:"id"`
-		PeerId                        sql.NullInt64  `db:"peer_id"`
-		ShortChannelId                sql.NullString `db:"short_channel_id"`
-		ChannelConfigLocal            int64          `db:"channel_config_local"`
-		ChannelConfigRemote           int64          `db:"channel_config_remote"`
-		State                         int64          `db:"state"`
-		Funder                        int64          `db:"funder"`
-		ChannelFlags                  int64          `db:"channel_flags"`
-		MinimumDepth                  int64          `db:"minimum_depth"`
-		NextIndexLocal                int64          `db:"next_index_local"`
-		NextIndexRemote               int64          `db:"next_index_remote"`
-		NextHtlcId                    int64          `db:"next_htlc_id"`
-		FundingTxId                   sqlblob        `db:"funding_tx_id"`
-		FundingTxOutnum               int64          `db:"funding_tx_outnum"`
-		FundingSatoshi                int64          `db:"funding_satoshi"`
-		FundingTxRemoteSigsReceived   int64          `db:"funding_tx_remote_sigs_received"`
-		OurFundingSatoshi             int64          `db:"our_funding_satoshi"`
-		FundingLockedRemote           int64          `db:"funding_locked_remote"`
-		PushMsatoshi                  int64          `db:"push_msatoshi"`
-		MsatoshiLocal                 int64          `db:"msatoshi_local"`
-		FundingkeyRemote              sqlblob        `db:"fundingkey_remote"`
-		RevocationBasepointRemote     sqlblob        `db:"revocation_basepoint_remote"`
-		PaymentBasepointRemote        sqlblob        `db:"payment_basepoint_remote"`
-		HtlcBasepointRemote           sqlblob        `db:"htlc_basepoint_remote"`
-		DelayedPaymentBasepointRemote sqlblob        `db:"delayed_payment_basepoint_remote"`
-		PerCommitRemote               sqlblob        `db:"per_commit_remote"`
-		OldPerCommitRemote            sqlblob        `db:"old_per_commit_remote"`
-		LocalFeeratePerKw             sql.NullInt64  `db:"local_feerate_per_kw"`
-		RemoteFeeratePerKw            sql.NullInt64  `db:"remote_feerate_per_kw"`
-		ShachainRemoteId              int64          `db:"shachain_remote_id"`
-		ShutdownScriptPubKeyRemote    sqlblob        `db:"shutdown_scriptpubkey_remote"`
-		ShutdownKeyidxLocal           int64          `db:"shutdown_keyidx_local"`
-		LastSentCommitState           sql.NullInt64  `db:"last_sent_commit_state"`
-		LastSentCommitId              sql.NullInt64  `db:"last_sent_commit_id"`
-		LastTx                        sqlblob        `db:"last_tx"`
-		LastSig                       sqlblob        `db:"last_sig"`
-		ClosingFeeReceived            sql.NullInt64  `db:"closing_fee_received"`
-		ClosingSigReceived            sqlblob        `db:"closing_sig_received"`
-		FirstBlocknum                 int64          `db:"first_blocknum"`
-		LastWasRevoke                 int64          `db:"last_was_revoke"`
-		InPaymentsOffered             sql.NullInt64  `db:"in_payments_offered"`
-		InPaymentsFulfilled           sql.NullInt64  `db:"in_payments_fulfilled"`
-		InMsatoshiOffered             sql.NullInt64  `db:"in_msatoshi_offered"`
-		InMsatoshiFulfilled           sql.NullInt64  `db:"in_msatoshi_fulfilled"`
-		OutPaymentsOffered            sql.NullInt64  `db:"out_payments_offered"`
-		OutPaymentsFulfilled          sql.NullInt64  `db:"out_payments_fulfilled"`
-		OutMsatoshiOffered            sql.NullInt64  `db:"out_msatoshi_offered"`
-		OutMsatoshiFulfilled          sql.NullInt64  `db:"out_msatoshi_fulfilled"`
-		MinPossibleFeerate            int64          `db:"min_possible_feerate"`
-		MaxPossibleFeerate            int64          `db:"max_possible_feerate"`
-		MsatoshiToUsMin               int64          `db:"msatoshi_to_us_min"`
-		MsatoshiToUsMax               int64          `db:"msatoshi_to_us_max"`
-		FuturePerCommitmentPoint      sqlblob        `db:"future_per_commitment_point"`
-		LastSentCommit                sqlblob        `db:"last_sent_commit"`
-		FeerateBase                   int64          `db:"feerate_base"`
-		FeeratePpm                    int64          `db:"feerate_ppm"`
-		RemoteUpfrontShutdownScript   sqlblob        `db:"remote_upfront_shutdown_script"`
-		RemoteAnnNodeSig              sqlblob        `db:"remote_ann_node_sig"`
-		RemoteAnnBitcoinSig           sqlblob        `db:"remote_ann_bitcoin_sig"`
-		OptionStaticRemotekey         int64          `db:"option_static_remotekey"`
-		ShutdownScriptPubKeyLocal     sqlblob        `db:"shutdown_scriptpubkey_local"`
-		OptionAnchorOutputs           sql.NullInt64  `db:"option_anchor_outputs"`
-		FullChannelId                 sqlblob        `db:"full_channel_id"`
-		FundingPSBT                   sqlblob        `db:"funding_psbt"`
-		Closer                        int64          `db:"closer"`
-		StateChangeReason             int64          `db:"state_change_reason"`
-		RevocationBasepointLocal      sqlblob        `db:"revocation_basepoint_local"`
-		PaymentBasepointLocal         sqlblob        `db:"payment_basepoint_local"`
-		HTLCBasepointLocal            sqlblob        `db:"htlc_basepoint_local"`
-		DelayedPaymentBasepointLocal  sqlblob        `db:"delayed_payment_basepoint_local"`
-		FundingPubkeyLocal            sqlblob        `db:"funding_pubkey_local"`
-		ShutdownWrongTxid             sqlblob        `db:"shutdown_wrong_txid"`
-		ShutdownWrongOutnum           int            `db:"shutdown_wrong_outnum"`
-		LocalStaticRemotekeyStart     int64          `db:"local_static_remotekey_start"`
-		RemoteStaticRemotekeyStart    int64          `db:"remote_static_remotekey_start"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "channel_feerates", struct {
-		ChannelId    int64 `db:"channel_id"`
-		HState       int64 `db:"hstate"`
-		FeeRatePerKw int64 `db:"feerate_per_kw"`
-	}{}, "channel_id, hstate"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "channel_htlcs", struct {
-		Id             int64         `db:"id"`
-		ChannelId      int64         `db:"channel_id"`
-		ChannelHTLCId  int64         `db:"channel_htlc_id"`
-		Direction      int64         `db:"direction"`
-		OriginHTLC     sql.NullInt64 `db:"origin_htlc"`
-		MSatoshi       int64         `db:"msatoshi"`
-		CLTVExpiry     int64         `db:"cltv_expiry"`
-		PaymentHash    sqlblob       `db:"payment_hash"`
-		PaymentKey     sqlblob       `db:"payment_key"`
-		RoutingOnion   sqlblob       `db:"routing_onion"`
-		FailureMessage sqlblob       `db:"failuremsg"`
-		MalformedOnion sql.NullInt64 `db:"malformed_onion"`
-		HState         int64         `db:"hstate"`
-		SharedSecret   sqlblob       `db:"shared_secret"`
-		ReceivedTime   sql.NullInt64 `db:"received_time"`
-		LocalFailMsg   sqlblob       `db:"localfailmsg"`
-		PartId         sql.NullInt64 `db:"partid"`
-		WeFilled       sql.NullInt64 `db:"we_filled"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "transactions", struct {
-		Id          sqlblob       `db:"id"`
-		Blockheight sql.NullInt64 `db:"blockheight"`
-		Txindex     sql.NullInt64 `db:"txindex"`
-		Rawtx       sqlblob       `db:"rawtx"`
-		Type        sql.NullInt64 `db:"type"`
-		ChannelId   sql.NullInt64 `db:"channel_id"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "transaction_annotations", struct {
-		TxId     sqlblob       `db:"txid"`
-		Idx      int64         `db:"idx"`
-		Location int64         `db:"location"`
-		Type     int64         `db:"type"`
-		Channel  sql.NullInt64 `db:"channel"`
-	}{}, "txid, idx"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "channeltxs", struct {
-		Id            int64   `db:"id"`
-		ChannelId     int64   `db:"channel_id"`
-		Type          int64   `db:"type"`
-		TransactionId sqlblob `db:"transaction_id"`
-		InputNum      int64   `db:"input_num"`
-		Blockheight   int64   `db:"blockheight"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "outputs", struct {
-		PrevOutTx           sqlblob       `db:"prev_out_tx"`
-		PrevOutIndex        int64         `db:"prev_out_index"`
-		Value               int64         `db:"value"`
-		Type                int64         `db:"type"`
-		Status              int64         `db:"status"`
-		Keyindex            int64         `db:"keyindex"`
-		ChannelId           sql.NullInt64 `db:"channel_id"`
-		PeerId              sqlblob       `db:"peer_id"`
-		CommitmentPoint     sqlblob       `db:"commitment_point"`
-		ConfirmationHeight  sql.NullInt64 `db:"confirmation_height"`
-		SpendHeight         sql.NullInt64 `db:"spend_height"`
-		ScriptPubKey        sqlblob       `db:"scriptpubkey"`
-		ReservedTil         sql.NullInt64 `db:"reserved_til"`
-		OptionAnchorOutputs sql.NullInt64 `db:"option_anchor_outputs"`
-	}{}, "prev_out_tx, prev_out_index"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "payments", struct {
-		Id              int64          `db:"id"`
-		Timestamp       int64          `db:"timestamp"`
-		Status          int64          `db:"status"`
-		PaymentHash     sqlblob        `db:"payment_hash"`
-		Destination     sqlblob        `db:"destination"`
-		Msatoshi        int64          `db:"msatoshi"`
-		PaymentPreimage sqlblob        `db:"payment_preimage"`
-		PathSecrets     sqlblob        `db:"path_secrets"`
-		RouteNodes      sqlblob        `db:"route_nodes"`
-		RouteChannels   sqlblob        `db:"route_channels"`
-		Failonionreply  sqlblob        `db:"failonionreply"`
-		Faildestperm    sql.NullInt64  `db:"faildestperm"`
-		Failindex       sql.NullInt64  `db:"failindex"`
-		Failcode        sql.NullInt64  `db:"failcode"`
-		Failnode        sqlblob        `db:"failnode"`
-		Failchannel     sql.NullString `db:"failchannel"`
-		Failupdate      sqlblob        `db:"failupdate"`
-		MsatoshiSent    int64          `db:"msatoshi_sent"`
-		Faildetail      sql.NullString `db:"faildetail"`
-		Description     sql.NullString `db:"description"`
-		Faildirection   sql.NullInt64  `db:"faildirection"`
-		Bolt11          sql.NullString `db:"bolt11"`
-		TotalMsat       int64          `db:"total_msat"`
-		PartId          int64          `db:"partid"`
-		LocalOfferId    sqlblob        `db:"local_offer_id"`
-	}{}, "payment_hash, partid"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "invoices", struct {
-		Id               int64          `db:"id"`
-		State            int64          `db:"state"`
-		Msatoshi         sql.NullInt64  `db:"msatoshi"`
-		PaymentHash      sqlblob        `db:"payment_hash"`
-		PaymentKey       sqlblob        `db:"payment_key"`
-		Label            string         `db:"label"`
-		ExpiryTime       int64          `db:"expiry_time"`
-		PayIndex         sql.NullInt64  `db:"pay_index"`
-		MsatoshiReceived sql.NullInt64  `db:"msatoshi_received"`
-		PaidTimestamp    sql.NullInt64  `db:"paid_timestamp"`
-		Bolt11           string         `db:"bolt11"`
-		Description      sql.NullString `db:"description"`
-		Features         sqlblob        `db:"features"`
-		LocalOfferId     sqlblob        `db:"local_offer_id"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "forwarded_payments", struct {
-		InHtlcId       sql.NullInt64 `db:"in_htlc_id"`
-		OutHtlcId      sql.NullInt64 `db:"out_htlc_id"`
-		InChannelScid  int64         `db:"in_channel_scid"`
-		OutChannelScid sql.NullInt64 `db:"out_channel_scid"`
-		InMsatoshi     int64         `db:"in_msatoshi"`
-		OutMsatoshi    sql.NullInt64 `db:"out_msatoshi"`
-		State          int64         `db:"state"`
-		ReceivedTime   int64         `db:"received_time"`
-		ResolvedTime   sql.NullInt64 `db:"resolved_time"`
-		Failcode       sql.NullInt64 `db:"failcode"`
-	}{}, "in_htlc_id, out_htlc_id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "shachains", struct {
-		Id       int64 `db:"id"`
-		MinIndex int64 `db:"min_index"`
-		NumValid int64 `db:"num_valid"`
-	}{}, "id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "shachain_known", struct {
-		ShachainId int64   `db:"shachain_id"`
-		Pos        int64   `db:"pos"`
-		Idx        int64   `db:"idx"`
-		Hash       sqlblob `db:"hash"`
-	}{}, "shachain_id, pos"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "utxoset", struct {
-		Txid         sqlblob       `db:"txid"`
-		Outnum       int64         `db:"outnum"`
-		Blockheight  int64         `db:"blockheight"`
-		Spendheight  sql.NullInt64 `db:"spendheight"`
-		Txindex      int64         `db:"txindex"`
-		ScriptPubKey sqlblob       `db:"scriptpubkey"`
-		Satoshis     int64         `db:"satoshis"`
-	}{}, "txid, outnum"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "penalty_bases", struct {
-		ChannelId int64   `db:"channel_id"`
-		CommitNum int64   `db:"commitnum"`
-		Txid      sqlblob `db:"txid"`
-		OutNum    int     `db:"outnum"`
-		Amount    int64   `db:"amount"`
-	}{}, "channel_id, commitnum"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "channel_state_changes", struct {
-		ChannelId int64  `db:"channel_id"`
-		Timestamp int64  `db:"timestamp"`
-		OldState  int64  `db:"old_state"`
-		NewState  int64  `db:"new_state"`
-		Cause     int64  `db:"cause"`
-		Message   string `db:"message"`
-	}{}, ""); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "offers", struct {
-		OfferId sqlblob `db:"offer_id"`
-		Bolt12  string  `db:"bolt12"`
-		Label   string  `db:"label"`
-		Status  int64   `db:"status"`
-	}{}, "offer_id"); err != nil {
-		return
-	}
-
-	if err := copyRows(pgx, "channel_funding_inflights", struct {
-		ChannelId                   int64   `db:"channel_id"`
-		FundingTxId                 sqlblob `db:"funding_tx_id"`
-		FundingTxOutnum             int     `db:"funding_tx_outnum"`
-		FundingFeerate              int     `db:"funding_feerate"`
-		FundingSatoshi              int64   `db:"funding_satoshi"`
-		OurFundingSatoshi           int64   `db:"our_funding_satoshi"`
-		FundingPSBT                 sqlblob `db:"funding_psbt"`
-		LastTx                      sqlblob `db:"last_tx"`
-		LastSig                     sqlblob `db:"last_sig"`
-		FundingTxRemoteSigsReceived int     `db:"funding_tx_remote_sigs_received"`
-	}{}, "channel_id, funding_tx_id"); err != nil {
-		return
-	}
-
-	// update sequences
-	var version string
-	if err := pg.Get(&version, "SELECT version()"); err != nil {
-		fmt.Println("failed to get database version")
+			return fail(exitSource, "error hashing sqlite tables: "+err.Error())
+		}
+		if len(tables) == 0 {
+			fmt.Println("  > no table changed since the snapshot.")
+		}
+	} else if *snapshot {
+		opts.checkpoint = true
+	}
+
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.name
+	}
+	if snapshotHashes != nil && len(tables) > 0 {
+		fmt.Println("  > tables changed since the snapshot, their rows will be deleted and copied again:", strings.Join(names, ", "))
 	} else {
-		if strings.Index(version, "CockroachDB") != -1 {
-			// skip this part in cockroach
-		} else {
-			if err := setSequence("channel_configs_id_seq"); err != nil {
-				return
-			}
-			if err := setSequence("channel_htlcs_id_seq"); err != nil {
-				return
+		fmt.Println("  > copying tables in this order:", strings.Join(names, ", "))
+	}
+
+	// count rows first so progress can be shown as a fraction of the total
+	totals, err := countRows(append([]table{{"vars", "name"}}, tables...))
+	if err != nil {
+		return fail(exitSource, "error counting rows: "+err.Error())
+	}
+	progressLine = newProgress(totals)
+
+	// update vars, which lightningd may have created already, whatever
+	// -on-conflict says
+	varsOpts := opts
+	varsOpts.onConflict = conflictUpdate
+	varsStats, err := copyRows(pgx, table{"vars", "name"}, varsOpts)
+	if err != nil {
+		return fail(exitCopy, "error copying vars: "+err.Error())
+	}
+	stats := []tableStats{varsStats}
+	if *resume {
+		for _, t := range tables {
+			progressLine.skip(t.name, checkpoints[t.name].Rows)
+		}
+	}
+	if len(deferred) > 0 && cockroach {
+		warn(fmt.Sprint("WARNING: cockroach can't defer foreign keys, these may fail: ", deferred))
+		deferred = nil
+	} else if len(deferred) > 0 {
+		fmt.Println("  > checking these foreign keys only after copying:", deferred)
+	}
+	var sequences []sequence
+	var prepared []string
+	var dropped []foreignKey
+	defer func() {
+		// only left here if something went wrong, and only after the
+		// prepared transactions holding the tables are gone
+		if dropped != nil {
+			addForeignKeys()
+		}
+	}()
+	defer func() {
+		// only left here if something went wrong before the final commit
+		finishPrepared(prepared, false)
+	}()
+	defer func() {
+		report.setStats(stats, sequences)
+	}()
+	if *jobs > 1 {
+		// with the references between tables out of the way any table can be
+		// copied alongside any other
+		cross, kept := crossForeignKeys(tables, fks)
+		fmt.Printf("  > dropping %d foreign keys between tables while copying, they are added back after the commit.\n", len(cross))
+		if err := dropForeignKeys(cross); err != nil {
+			return fail(exitSchema, "error dropping foreign keys: "+err.Error())
+		}
+		dropped = cross
+
+		var selfDeferred []foreignKey
+		for _, fk := range deferred {
+			if fk.Table == fk.References {
+				selfDeferred = append(selfDeferred, fk)
 			}
-			if err := setSequence("channels_id_seq"); err != nil {
-				return
+		}
+
+		groups := packGroups(groupTables(tables, kept), *jobs, totals)
+		fmt.Printf("  > copying tables in %d transactions with %d connections.\n", len(groups), *jobs)
+
+		var s []tableStats
+		s, sequences, prepared, err = copyInParallel(groups, selfDeferred, opts, *jobs)
+		stats = append(stats, s...)
+		if err != nil {
+			if errors.Is(err, errSequence) {
+				return fail(exitSequence, err.Error())
 			}
-			if err := setSequence("channeltxs_id_seq"); err != nil {
-				return
+			return fail(exitCopy, err.Error())
+		}
+	} else {
+		if *resume {
+			opts.deferred = deferred
+		} else if err := deferConstraints(pgx, deferred); err != nil {
+			return fail(exitCopy, "error deferring foreign keys: "+err.Error())
+		}
+		if snapshotHashes != nil {
+			if err := clearTables(pgx, tables); err != nil {
+				return fail(exitCopy, "error deleting rows copied from the snapshot: "+err.Error())
 			}
-			if err := setSequence("invoices_id_seq"); err != nil {
-				return
+		}
+
+		// update all the other tables except version and db_upgrades
+		for _, t := range tables {
+			var s tableStats
+			if *resume {
+				s, err = copyRowsResumable(t, checkpoints[t.name], opts, *checkpointRows)
+			} else {
+				s, err = copyRows(pgx, t, opts)
 			}
-			if err := setSequence("payments_id_seq"); err != nil {
-				return
+			if err != nil {
+				return fail(exitCopy, "error copying "+t.name+": "+err.Error())
 			}
-			if err := setSequence("peers_id_seq"); err != nil {
-				return
+			stats = append(stats, s)
+		}
+
+		if !*resume {
+			if err := restoreConstraints(pgx, deferred); err != nil {
+				return fail(exitCopy, "error checking foreign keys: "+err.Error())
 			}
-			if err := setSequence("shachains_id_seq"); err != nil {
-				return
+		}
+
+		// update sequences
+		if cockroach {
+			sequences, err = discoverCockroachSequences(pgx, rel.tables)
+		} else {
+			sequences, err = discoverSequences(pgx, rel.tables)
+		}
+		if err != nil {
+			return fail(exitSequence, "error discovering sequences: "+err.Error())
+		}
+		for i := range sequences {
+			if err := setSequence(pgx, &sequences[i], *dryRun); err != nil {
+				return fail(exitSequence, "error setting "+sequences[i].Name+": "+err.Error())
 			}
 		}
 	}
 
+	// with -jobs htlc_sigs may be in a prepared transaction this one can't
+	// see, so those are checked after the commit
+	if *jobs <= 1 {
+		ok, err := checkHtlcSigs(pgx)
+		if errors.Is(err, errSource) {
+			return fail(exitSource, "error checking htlc_sigs: "+err.Error())
+		} else if err != nil {
+			return fail(exitConnection, "error checking htlc_sigs: "+err.Error())
+		}
+		if !ok {
+			return fail(exitHtlcSigs, "signatures of pending htlcs were not copied correctly.")
+		}
+	}
+
+	if *dryRun {
+		report.Outcome = outcomeDryRun
+		fmt.Println("  > dry run, rolling back. this is what would have been written:")
+		printStats(stats)
+		fmt.Println("  > sequences that would be set:")
+		for _, s := range sequences {
+			fmt.Println("      " + s.String())
+		}
+		return exitOK
+	}
+
+	if *resume {
+		if _, err := pgx.Exec(`DROP TABLE mcldsp_checkpoint`); err != nil {
+			return fail(exitCommit, "error dropping checkpoint table: "+err.Error())
+		}
+	}
+
+	// the next run needs these to know what changed since this one
+	if *snapshot {
+		hashes := currentHashes
+		if hashes == nil {
+			hashes = statsHashes(stats)
+		}
+		if err := saveSnapshotHashes(pgx, hashes); err != nil {
+			return fail(exitCommit, "error saving snapshot hashes: "+err.Error())
+		}
+	} else if snapshotHashes != nil {
+		if _, err := pgx.Exec(`DROP TABLE mcldsp_snapshot`); err != nil {
+			return fail(exitCommit, "error dropping snapshot table: "+err.Error())
+		}
+	}
+
 	// end it
-	err = pgx.Commit()
-	if err != nil {
-		fmt.Println("error on final commit", err)
-		return
+	if len(prepared) > 0 {
+		// the main transaction joins the ones from the other connections
+		gid := fmt.Sprintf("mcldsp_%d_main", os.Getpid())
+		if _, err := pgx.Exec(`PREPARE TRANSACTION '` + gid + `'`); err != nil {
+			return fail(exitCommit, "error preparing main transaction: "+err.Error())
+		}
+		all := append(prepared, gid)
+		prepared = nil
+		if err := finishPrepared(all, true); err != nil {
+			return fail(exitCommit, "error on final commit: "+err.Error())
+		}
+	} else {
+		err = pgx.Commit()
+		if err != nil {
+			return fail(exitCommit, "error on final commit: "+err.Error())
+		}
+	}
+
+	if dropped != nil {
+		err := addForeignKeys()
+		dropped = nil
+		if err != nil {
+			return fail(exitCommit, "the data is committed, but adding back the foreign keys failed: "+err.Error()+". they are kept in mcldsp_foreign_keys and added back the next time mcldsp runs.")
+		}
 	}
 
+	if *jobs > 1 {
+		ok, err := checkHtlcSigs(pg)
+		if errors.Is(err, errSource) {
+			return fail(exitSource, "error checking htlc_sigs, the data is already committed: "+err.Error())
+		} else if err != nil {
+			return fail(exitConnection, "error checking htlc_sigs, the data is already committed: "+err.Error())
+		}
+		if !ok {
+			return fail(exitHtlcSigs, "signatures of pending htlcs were not copied correctly, the data is already committed.")
+		}
+	}
+
+	report.Outcome = outcomeMigrated
+	printStats(stats)
+	fmt.Println("  > sequences:")
+	for _, s := range sequences {
+		fmt.Println("      " + s.String())
+	}
+	fmt.Println("  > the sqlite database was backed up to " + backupPath + ".")
+	if *snapshot {
+		fmt.Println("  > all data in the snapshot moved. now stop lightningd and run mcldsp again without -snapshot, it will copy again only the tables that changed since.")
+		return exitOK
+	}
 	fmt.Println("  > all data moved. you should now stop using sqlite and use postgres only.")
+	return exitOK
+}
+
+// checkHtlcSigs prints the result of verifyHtlcSigs and tells if it is fine.
+// An error means the check couldn't be done, not that it failed, and wraps
+// errSource if sqlite couldn't be read.
+func checkHtlcSigs(pgq sqlx.Queryer) (bool, error) {
+	fmt.Println("  > checking the signatures of pending htlcs.")
+	problems, err := verifyHtlcSigs(pgq)
+	if err != nil {
+		return false, err
+	}
+	for _, problem := range problems {
+		fmt.Println("      " + problem)
+	}
+	return len(problems) == 0, nil
+}
+
+func printStats(stats []tableStats) {
+	for _, s := range stats {
+		fmt.Println("      " + s.String())
+		for _, key := range s.conflicts {
+			fmt.Println("          already on postgres: " + key)
+		}
+	}
+	peakMemoryMu.Lock()
+	defer peakMemoryMu.Unlock()
+	if peakMemory > 0 {
+		fmt.Printf("      peak heap while copying: %.1f MiB\n", float64(peakMemory)/(1<<20))
+	}
 }
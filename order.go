@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// foreignKey is a reference from one postgres table to another (or to itself).
+type foreignKey struct {
+	Name       string `db:"constraint_name"`
+	Table      string `db:"table_name"`
+	References string `db:"referenced_table"`
+	Deferrable bool   `db:"deferrable"`
+}
+
+func (fk foreignKey) String() string {
+	return fmt.Sprintf("%s (%s -> %s)", fk.Name, fk.Table, fk.References)
+}
+
+func loadForeignKeys(pgx *sqlx.Tx) (fks []foreignKey, err error) {
+	err = pgx.Select(&fks, `
+SELECT c.conname AS constraint_name,
+       t.relname AS table_name,
+       r.relname AS referenced_table,
+       c.condeferrable AS deferrable
+FROM pg_constraint c
+JOIN pg_class t ON t.oid = c.conrelid
+JOIN pg_class r ON r.oid = c.confrelid
+JOIN pg_namespace n ON n.oid = t.relnamespace
+WHERE c.contype = 'f' AND n.nspname = 'public'
+ORDER BY t.relname, c.conname
+    `)
+	if err != nil {
+		fmt.Println("error reading foreign keys", err)
+		return nil, err
+	}
+	return fks, nil
+}
+
+// sortTables orders tables so each one comes after the tables it references,
+// otherwise keeping the given order. References that no order can satisfy,
+// self-references and cycles, are returned so their checks can be deferred.
+func sortTables(tables []table, fks []foreignKey) (sorted []table, deferred []foreignKey) {
+	pending := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		pending[t.name] = true
+	}
+
+	for len(sorted) < len(tables) {
+		// the first pending table whose references are all in place
+		next := -1
+		for i, t := range tables {
+			if !pending[t.name] {
+				continue
+			}
+			ready := true
+			for _, fk := range fks {
+				if fk.Table == t.name && fk.References != t.name && pending[fk.References] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+
+		if next == -1 {
+			// a cycle, break it at the first pending table
+			for i, t := range tables {
+				if pending[t.name] {
+					next = i
+					break
+				}
+			}
+			for _, fk := range fks {
+				if fk.Table == tables[next].name && fk.References != fk.Table && pending[fk.References] {
+					deferred = append(deferred, fk)
+				}
+			}
+		}
+
+		t := tables[next]
+		for _, fk := range fks {
+			if fk.Table == t.name && fk.References == t.name {
+				deferred = append(deferred, fk)
+			}
+		}
+		sorted = append(sorted, t)
+		pending[t.name] = false
+	}
+
+	return sorted, deferred
+}
+
+// deferConstraints makes the given foreign keys be checked only at commit time
+// (or at restoreConstraints, whatever comes first).
+func deferConstraints(pgx *sqlx.Tx, fks []foreignKey) error {
+	for _, fk := range fks {
+		if !fk.Deferrable {
+			_, err := pgx.Exec(`ALTER TABLE ` + fk.Table + ` ALTER CONSTRAINT ` + fk.Name + ` DEFERRABLE`)
+			if err != nil {
+				fmt.Println("error making "+fk.String()+" deferrable", err)
+				return err
+			}
+		}
+		if _, err := pgx.Exec(`SET CONSTRAINTS ` + fk.Name + ` DEFERRED`); err != nil {
+			fmt.Println("error deferring "+fk.String(), err)
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreConstraints checks the deferred foreign keys now and puts them back as
+// they were.
+func restoreConstraints(pgx *sqlx.Tx, fks []foreignKey) error {
+	for _, fk := range fks {
+		// pending checks must run before the constraint can be altered
+		if _, err := pgx.Exec(`SET CONSTRAINTS ` + fk.Name + ` IMMEDIATE`); err != nil {
+			fmt.Println("error checking "+fk.String(), err)
+			return err
+		}
+		if !fk.Deferrable {
+			_, err := pgx.Exec(`ALTER TABLE ` + fk.Table + ` ALTER CONSTRAINT ` + fk.Name + ` NOT DEFERRABLE`)
+			if err != nil {
+				fmt.Println("error restoring "+fk.String(), err)
+				return err
+			}
+		}
+	}
+	return nil
+}
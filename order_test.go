@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func tableNames(tables []table) []string {
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.name
+	}
+	return names
+}
+
+func fkNames(fks []foreignKey) []string {
+	var names []string
+	for _, fk := range fks {
+		names = append(names, fk.Name)
+	}
+	return names
+}
+
+func TestSortTables(t *testing.T) {
+	fk := func(from, to string) foreignKey {
+		return foreignKey{Name: from + "_" + to + "_fkey", Table: from, References: to}
+	}
+
+	tests := []struct {
+		name     string
+		tables   []string
+		fks      []foreignKey
+		sorted   []string
+		deferred []string
+	}{
+		{
+			name:   "no references keeps the order",
+			tables: []string{"c", "a", "b"},
+			sorted: []string{"c", "a", "b"},
+		},
+		{
+			name:   "already in order",
+			tables: []string{"a", "b"},
+			fks:    []foreignKey{fk("b", "a")},
+			sorted: []string{"a", "b"},
+		},
+		{
+			name:   "referenced table comes first",
+			tables: []string{"c", "b", "a"},
+			fks:    []foreignKey{fk("c", "b"), fk("b", "a")},
+			sorted: []string{"a", "b", "c"},
+		},
+		{
+			name:   "references to tables not copied are ignored",
+			tables: []string{"b", "a"},
+			fks:    []foreignKey{fk("b", "x")},
+			sorted: []string{"b", "a"},
+		},
+		{
+			name:     "self reference is deferred",
+			tables:   []string{"a", "b"},
+			fks:      []foreignKey{fk("a", "a"), fk("b", "a")},
+			sorted:   []string{"a", "b"},
+			deferred: []string{"a_a_fkey"},
+		},
+		{
+			name:     "cycle is broken at the first table",
+			tables:   []string{"a", "b", "c"},
+			fks:      []foreignKey{fk("a", "b"), fk("b", "a"), fk("c", "a")},
+			sorted:   []string{"a", "b", "c"},
+			deferred: []string{"a_b_fkey"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := make([]table, len(tt.tables))
+			for i, name := range tt.tables {
+				tables[i] = table{name: name}
+			}
+
+			sorted, deferred := sortTables(tables, tt.fks)
+			if got := tableNames(sorted); !reflect.DeepEqual(got, tt.sorted) {
+				t.Errorf("sorted = %v, want %v", got, tt.sorted)
+			}
+			if got := fkNames(deferred); !reflect.DeepEqual(got, tt.deferred) {
+				t.Errorf("deferred = %v, want %v", got, tt.deferred)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// groupTables splits the tables into groups that don't reference each other,
+// keeping the given order inside each group.
+func groupTables(tables []table, fks []foreignKey) [][]table {
+	parent := make(map[string]string, len(tables))
+	for _, t := range tables {
+		parent[t.name] = t.name
+	}
+	var root func(string) string
+	root = func(name string) string {
+		if parent[name] != name {
+			parent[name] = root(parent[name])
+		}
+		return parent[name]
+	}
+	for _, fk := range fks {
+		if _, ok := parent[fk.Table]; !ok {
+			continue
+		}
+		if _, ok := parent[fk.References]; !ok {
+			continue
+		}
+		parent[root(fk.Table)] = root(fk.References)
+	}
+
+	var groups [][]table
+	index := make(map[string]int)
+	for _, t := range tables {
+		r := root(t.name)
+		i, ok := index[r]
+		if !ok {
+			i = len(groups)
+			index[r] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], t)
+	}
+	return groups
+}
+
+// crossForeignKeys splits the foreign keys between the given tables into the
+// ones from a table to another and the rest. Rows referenced across
+// transactions can't be seen by the check until everything is committed, so
+// the first ones are dropped while copying in parallel.
+func crossForeignKeys(tables []table, fks []foreignKey) (cross, kept []foreignKey) {
+	copied := make(map[string]bool, len(tables))
+	for _, t := range tables {
+		copied[t.name] = true
+	}
+	for _, fk := range fks {
+		if fk.Table != fk.References && copied[fk.Table] && copied[fk.References] {
+			cross = append(cross, fk)
+		} else {
+			kept = append(kept, fk)
+		}
+	}
+	return cross, kept
+}
+
+// packGroups joins groups into at most n, balancing their row counts, so there
+// is a prepared transaction per connection instead of one per group. Ties go to
+// the slot with fewer groups, so empty tables are spread too.
+func packGroups(groups [][]table, n int, totals map[string]int) [][]table {
+	if len(groups) <= n {
+		return groups
+	}
+
+	sizes := make([]int, len(groups))
+	order := make([]int, len(groups))
+	for i, group := range groups {
+		for _, t := range group {
+			sizes[i] += totals[t.name]
+		}
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return sizes[order[a]] > sizes[order[b]] })
+
+	packed := make([][]table, n)
+	load := make([]int, n)
+	count := make([]int, n)
+	for _, i := range order {
+		least := 0
+		for j := range load {
+			if load[j] < load[least] || load[j] == load[least] && count[j] < count[least] {
+				least = j
+			}
+		}
+		packed[least] = append(packed[least], groups[i]...)
+		load[least] += sizes[i]
+		count[least]++
+	}
+
+	nonEmpty := packed[:0]
+	for _, group := range packed {
+		if len(group) > 0 {
+			nonEmpty = append(nonEmpty, group)
+		}
+	}
+	return nonEmpty
+}
+
+// dropForeignKeys drops the given foreign keys in a transaction of its own,
+// keeping their definitions in mcldsp_foreign_keys so addForeignKeys can put
+// them back, even on a later run if this one dies.
+func dropForeignKeys(fks []foreignKey) error {
+	tx, err := pg.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+CREATE TABLE mcldsp_foreign_keys (
+  table_name TEXT NOT NULL,
+  constraint_name TEXT NOT NULL,
+  definition TEXT NOT NULL,
+  PRIMARY KEY (table_name, constraint_name)
+)
+    `)
+	if err != nil {
+		fmt.Println("error creating foreign keys table", err)
+		return err
+	}
+	for _, fk := range fks {
+		_, err := tx.Exec(`
+INSERT INTO mcldsp_foreign_keys (table_name, constraint_name, definition)
+SELECT $1, conname, pg_get_constraintdef(oid) FROM pg_constraint
+WHERE conrelid = $1::regclass AND conname = $2
+        `, fk.Table, fk.Name)
+		if err != nil {
+			fmt.Println("error saving "+fk.String(), err)
+			return err
+		}
+		if _, err := tx.Exec(`ALTER TABLE ` + fk.Table + ` DROP CONSTRAINT ` + fk.Name); err != nil {
+			fmt.Println("error dropping "+fk.String(), err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addForeignKeys adds back whatever dropForeignKeys dropped, checking every
+// row against them. It does nothing if nothing was dropped.
+func addForeignKeys() error {
+	tx, err := pg.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.Get(&exists, `SELECT to_regclass('public.mcldsp_foreign_keys') IS NOT NULL`)
+	if err != nil || !exists {
+		return err
+	}
+
+	var list []struct {
+		Table      string `db:"table_name"`
+		Name       string `db:"constraint_name"`
+		Definition string `db:"definition"`
+	}
+	err = tx.Select(&list, `SELECT table_name, constraint_name, definition FROM mcldsp_foreign_keys`)
+	if err != nil {
+		fmt.Println("error loading dropped foreign keys", err)
+		return err
+	}
+	if len(list) > 0 {
+		fmt.Printf("  > adding back %d foreign keys and checking them.\n", len(list))
+	}
+	for _, fk := range list {
+		_, err := tx.Exec(`ALTER TABLE ` + fk.Table + ` ADD CONSTRAINT ` + fk.Name + ` ` + fk.Definition)
+		if err != nil {
+			fmt.Println("error adding back "+fk.Name+" on "+fk.Table, err)
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DROP TABLE mcldsp_foreign_keys`); err != nil {
+		fmt.Println("error dropping foreign keys table", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// copyInParallel copies each group of tables on its own connection, jobs at a
+// time. Every group is copied in its own transaction, together with its
+// sequences, and then prepared with PREPARE TRANSACTION instead of committed,
+// so the caller can commit all of them or none. The ids of the prepared
+// transactions are returned even on error so they can be rolled back.
+func copyInParallel(groups [][]table, deferred []foreignKey, opts copyOptions, jobs int) (
+	stats []tableStats, sequences []sequence, prepared []string, err error,
+) {
+	nonEmpty := groups[:0:0]
+	for _, group := range groups {
+		if len(group) > 0 {
+			nonEmpty = append(nonEmpty, group)
+		}
+	}
+	groups = nonEmpty
+
+	var setting string
+	if err := pg.Get(&setting, "SHOW max_prepared_transactions"); err != nil {
+		fmt.Println("error checking max_prepared_transactions", err)
+		return nil, nil, nil, err
+	}
+	// one per group plus the main transaction
+	if n, _ := strconv.Atoi(setting); n < len(groups)+1 {
+		err = fmt.Errorf("-jobs needs max_prepared_transactions to be at least %d on postgres, it is %s", len(groups)+1, setting)
+		fmt.Println(err)
+		return nil, nil, nil, err
+	}
+
+	type result struct {
+		stats     []tableStats
+		sequences []sequence
+		gid       string
+		err       error
+	}
+	results := make([]result, len(groups))
+
+	var mu sync.Mutex
+	var failed bool
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				r := &results[i]
+				r.gid = fmt.Sprintf("mcldsp_%d_%d", os.Getpid(), i)
+				r.stats, r.sequences, r.err = copyGroup(groups[i], deferred, opts, r.gid, func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					return failed
+				})
+				if r.err != nil {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range groups {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for _, r := range results {
+		if r.err == nil && r.gid != "" {
+			prepared = append(prepared, r.gid)
+		}
+		if r.err != nil && err == nil {
+			err = r.err
+		}
+		stats = append(stats, r.stats...)
+		sequences = append(sequences, r.sequences...)
+	}
+	return stats, sequences, prepared, err
+}
+
+// copyGroup copies the tables of a group in a transaction of its own and
+// prepares it with the given id. It gives up early if stop() says another
+// group failed.
+func copyGroup(tables []table, deferred []foreignKey, opts copyOptions, gid string, stop func() bool) (
+	stats []tableStats, sequences []sequence, err error,
+) {
+	pgx, err := pg.Beginx()
+	if err != nil {
+		fmt.Println(err)
+		return nil, nil, err
+	}
+	defer pgx.Rollback()
+
+	var groupDeferred []foreignKey
+	for _, fk := range deferred {
+		for _, t := range tables {
+			if fk.Table == t.name {
+				groupDeferred = append(groupDeferred, fk)
+			}
+		}
+	}
+	if err := deferConstraints(pgx, groupDeferred); err != nil {
+		return nil, nil, err
+	}
+
+	for _, t := range tables {
+		if stop() {
+			return stats, nil, fmt.Errorf("stopped copying %s as another table failed", t.name)
+		}
+		s, err := copyRows(pgx, t, opts)
+		if err != nil {
+			return stats, nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	if err := restoreConstraints(pgx, groupDeferred); err != nil {
+		return stats, nil, err
+	}
+
+	sequences, err = discoverSequences(pgx, tables)
+	if err != nil {
+		return stats, nil, fmt.Errorf("%w: %s", errSequence, err)
+	}
+	for i := range sequences {
+		if err := setSequence(pgx, &sequences[i], false); err != nil {
+			return stats, sequences, fmt.Errorf("%w: %s", errSequence, err)
+		}
+	}
+
+	if stop() {
+		return stats, sequences, fmt.Errorf("not preparing %s as another table failed", gid)
+	}
+	if _, err := pgx.Exec(`PREPARE TRANSACTION '` + gid + `'`); err != nil {
+		fmt.Println("error preparing transaction "+gid, err)
+		return stats, sequences, err
+	}
+
+	return stats, sequences, nil
+}
+
+// finishPrepared commits or rolls back every prepared transaction.
+func finishPrepared(gids []string, commit bool) error {
+	action := "ROLLBACK PREPARED"
+	if commit {
+		action = "COMMIT PREPARED"
+	}
+
+	for i, gid := range gids {
+		if _, err := pg.Exec(action + ` '` + gid + `'`); err != nil {
+			fmt.Printf("error on %s '%s': %s. these transactions are left prepared, finish them by hand: %v\n",
+				action, gid, err, gids[i:])
+			return err
+		}
+	}
+	return nil
+}
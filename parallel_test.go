@@ -0,0 +1,181 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func groupNames(groups [][]table) [][]string {
+	names := make([][]string, len(groups))
+	for i, group := range groups {
+		names[i] = tableNames(group)
+	}
+	return names
+}
+
+func TestGroupTables(t *testing.T) {
+	fk := func(from, to string) foreignKey {
+		return foreignKey{Name: from + "_" + to + "_fkey", Table: from, References: to}
+	}
+
+	tests := []struct {
+		name   string
+		tables []string
+		fks    []foreignKey
+		groups [][]string
+	}{
+		{
+			name:   "no references",
+			tables: []string{"a", "b", "c"},
+			groups: [][]string{{"a"}, {"b"}, {"c"}},
+		},
+		{
+			name:   "references join groups in the given order",
+			tables: []string{"a", "b", "c", "d"},
+			fks:    []foreignKey{fk("c", "a"), fk("d", "b")},
+			groups: [][]string{{"a", "c"}, {"b", "d"}},
+		},
+		{
+			name:   "indirect references",
+			tables: []string{"a", "b", "c"},
+			fks:    []foreignKey{fk("b", "a"), fk("b", "c")},
+			groups: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name:   "self references and tables not copied don't join anything",
+			tables: []string{"a", "b"},
+			fks:    []foreignKey{fk("a", "a"), fk("b", "x")},
+			groups: [][]string{{"a"}, {"b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tables := make([]table, len(tt.tables))
+			for i, name := range tt.tables {
+				tables[i] = table{name: name}
+			}
+
+			if got := groupNames(groupTables(tables, tt.fks)); !reflect.DeepEqual(got, tt.groups) {
+				t.Errorf("groups = %v, want %v", got, tt.groups)
+			}
+		})
+	}
+}
+
+func TestGroupTablesSchema162(t *testing.T) {
+	tables := releaseFor(162).tables
+	fks := parseSchema(schema162).foreignKeys
+
+	// with every foreign key in place most tables end up together
+	groups := groupTables(tables, fks)
+	group := make(map[string]int)
+	count := 0
+	for i, g := range groups {
+		for _, table := range g {
+			group[table.name] = i
+			count++
+		}
+	}
+	if count != len(tables) {
+		t.Fatalf("got %d tables, want %d", count, len(tables))
+	}
+	for _, fk := range fks {
+		if group[fk.Table] != group[fk.References] {
+			t.Errorf("%s and %s are in different groups", fk.Table, fk.References)
+		}
+	}
+
+	// without the ones -jobs drops every table is on its own
+	_, kept := crossForeignKeys(tables, fks)
+	if len(kept) != 0 {
+		t.Errorf("kept %v, schema 162 has only references between tables", kept)
+	}
+	if groups := groupTables(tables, kept); len(groups) != len(tables) {
+		t.Errorf("got %d groups, want %d", len(groups), len(tables))
+	}
+}
+
+func TestCrossForeignKeys(t *testing.T) {
+	tables := []table{{name: "a"}, {name: "b"}}
+	fks := []foreignKey{
+		{Name: "b_a", Table: "b", References: "a"},
+		{Name: "a_a", Table: "a", References: "a"},
+		{Name: "b_x", Table: "b", References: "x"},
+		{Name: "x_a", Table: "x", References: "a"},
+	}
+
+	cross, kept := crossForeignKeys(tables, fks)
+	if got := fkNames(cross); !reflect.DeepEqual(got, []string{"b_a"}) {
+		t.Errorf("cross = %v", got)
+	}
+	if got := fkNames(kept); !reflect.DeepEqual(got, []string{"a_a", "b_x", "x_a"}) {
+		t.Errorf("kept = %v", got)
+	}
+}
+
+func TestPackGroups(t *testing.T) {
+	tests := []struct {
+		name   string
+		groups [][]string
+		totals map[string]int
+		n      int
+		packed [][]string
+	}{
+		{
+			name:   "fewer groups than connections",
+			groups: [][]string{{"a"}, {"b"}},
+			n:      4,
+			packed: [][]string{{"a"}, {"b"}},
+		},
+		{
+			name:   "biggest groups first, each to the least loaded",
+			groups: [][]string{{"a"}, {"b"}, {"c"}, {"d"}},
+			totals: map[string]int{"a": 10, "b": 100, "c": 50, "d": 40},
+			n:      2,
+			packed: [][]string{{"b"}, {"c", "d", "a"}},
+		},
+		{
+			name:   "groups stay together",
+			groups: [][]string{{"a", "b"}, {"c"}, {"d"}},
+			totals: map[string]int{"a": 10, "b": 10, "c": 30, "d": 5},
+			n:      2,
+			packed: [][]string{{"c"}, {"a", "b", "d"}},
+		},
+		{
+			name:   "empty tables keep their order",
+			groups: [][]string{{"a"}, {"b"}, {"c"}},
+			n:      1,
+			packed: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name:   "empty tables spread over the other connections",
+			groups: [][]string{{"a"}, {"b"}, {"c"}, {"d"}, {"e"}},
+			totals: map[string]int{"a": 100},
+			n:      4,
+			packed: [][]string{{"a"}, {"b", "e"}, {"c"}, {"d"}},
+		},
+		{
+			name:   "no empty transactions",
+			groups: [][]string{{"a"}, {}, {}},
+			totals: map[string]int{"a": 100},
+			n:      2,
+			packed: [][]string{{"a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := make([][]table, len(tt.groups))
+			for i, names := range tt.groups {
+				for _, name := range names {
+					groups[i] = append(groups[i], table{name: name})
+				}
+			}
+
+			if got := groupNames(packGroups(groups, tt.n, tt.totals)); !reflect.DeepEqual(got, tt.packed) {
+				t.Errorf("packed = %v, want %v", got, tt.packed)
+			}
+		})
+	}
+}
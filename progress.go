@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress shows how far the copy is, from counts taken before it started. On
+// a terminal it is a single line rewritten in place, otherwise a plain line is
+// printed every now and then so logs don't fill up.
+type progress struct {
+	mu sync.Mutex
+
+	tty      bool
+	interval time.Duration
+
+	totals map[string]int
+	done   map[string]int
+	total  int
+	copied int // rows copied by this run, for the overall rate
+
+	started      time.Time
+	tableStarted map[string]time.Time
+	tableCopied  map[string]int
+	last         time.Time
+}
+
+// progressLine is nil when no progress should be shown, which is fine for
+// every method.
+var progressLine *progress
+
+// countRows is the pre-scan: how many rows each table has on sqlite.
+func countRows(tables []table) (map[string]int, error) {
+	totals := make(map[string]int, len(tables))
+	for _, t := range tables {
+		var count int
+		if err := lite.Get(&count, `SELECT count(*) FROM `+t.name); err != nil {
+			fmt.Println("error counting "+t.name+" rows", err)
+			return nil, err
+		}
+		totals[t.name] = count
+	}
+	return totals, nil
+}
+
+func newProgress(totals map[string]int) *progress {
+	p := &progress{
+		interval:     10 * time.Second,
+		totals:       totals,
+		done:         make(map[string]int),
+		started:      time.Now(),
+		tableStarted: make(map[string]time.Time),
+		tableCopied:  make(map[string]int),
+	}
+	for _, n := range totals {
+		p.total += n
+	}
+
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.tty = true
+		p.interval = 200 * time.Millisecond
+	}
+	return p
+}
+
+// skip counts rows as done without copying them, like the ones a previous run
+// already committed.
+func (p *progress) skip(tableName string, n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[tableName] += n
+}
+
+// row is called by copyRows for every row it reads.
+func (p *progress) row(tableName string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := p.tableStarted[tableName]; !ok {
+		p.tableStarted[tableName] = now
+	}
+	p.done[tableName]++
+	p.tableCopied[tableName]++
+	p.copied++
+
+	if now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+
+	if p.tty {
+		fmt.Print("\r\033[K" + p.line(tableName, now))
+	} else {
+		fmt.Println("  > " + p.line(tableName, now))
+	}
+}
+
+// clear removes the line from the terminal so other output can follow.
+func (p *progress) clear() {
+	if p == nil || !p.tty {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Print("\r\033[K")
+	p.last = time.Time{}
+}
+
+// line is like "channel_htlcs 1200/5000 (800 rows/s, eta 5s), all 9000/40000 (eta 40s)".
+func (p *progress) line(tableName string, now time.Time) string {
+	tableRate := rate(p.tableCopied[tableName], now.Sub(p.tableStarted[tableName]))
+	allRate := rate(p.copied, now.Sub(p.started))
+
+	done := 0
+	for _, n := range p.done {
+		done += n
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %d/%d (%.0f rows/s, eta %s)", tableName,
+		p.done[tableName], p.totals[tableName], tableRate, eta(p.totals[tableName]-p.done[tableName], tableRate))
+	fmt.Fprintf(&b, ", all %d/%d (eta %s)", done, p.total, eta(p.total-done, allRate))
+	return b.String()
+}
+
+func rate(rows int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(rows) / elapsed.Seconds()
+}
+
+func eta(remaining int, rate float64) string {
+	if remaining <= 0 {
+		return "0s"
+	}
+	if rate <= 0 {
+		return "?"
+	}
+	return time.Duration(float64(remaining) / rate * float64(time.Second)).Round(time.Second).String()
+}
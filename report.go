@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// possible outcomes in the report.
+const (
+	outcomeFailed         = "failed"
+	outcomeMigrated       = "migrated"
+	outcomeDryRun         = "dry-run"
+	outcomeVerifyMatch    = "verify-match"
+	outcomeVerifyMismatch = "verify-mismatch"
+	outcomeScript         = "script-written"
+)
+
+// migrationReport is what -report writes, the same things that are printed
+// along the way but for other programs to read.
+type migrationReport struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Seconds  float64   `json:"seconds"`
+
+	Source struct {
+		Path                string `json:"path"`
+		Version             int    `json:"version,omitempty"`
+		Snapshot            bool   `json:"snapshot,omitempty"` // migrated from the backup, not from path
+		SnapshotDataVersion int64  `json:"snapshot_data_version,omitempty"`
+		Backup              string `json:"backup,omitempty"`
+		BackupSHA256        string `json:"backup_sha256,omitempty"`
+	} `json:"source"`
+	Target struct {
+		Backend string `json:"backend,omitempty"` // postgres or cockroachdb
+		Version int    `json:"version,omitempty"`
+		Script  string `json:"script,omitempty"`
+	} `json:"target"`
+
+	Tables    []tableReport    `json:"tables"`
+	Sequences []sequenceReport `json:"sequences"`
+	Warnings  []string         `json:"warnings"`
+
+	Outcome  string `json:"outcome"`
+	ExitCode int    `json:"exit_code"`
+	Failure  string `json:"failure,omitempty"` // see failureClasses
+	Error    string `json:"error,omitempty"`
+}
+
+type tableReport struct {
+	Name      string   `json:"name"`
+	Read      int      `json:"read"`
+	Written   int      `json:"written"`
+	Skipped   int      `json:"skipped"`
+	Updated   int      `json:"updated"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	Seconds   float64  `json:"seconds"`
+}
+
+type sequenceReport struct {
+	Name   string `json:"name,omitempty"`
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Kind   string `json:"kind"`
+	Old    int64  `json:"old"`
+	New    int64  `json:"new"`
+}
+
+var report = migrationReport{
+	Started:   time.Now(),
+	Tables:    []tableReport{},
+	Sequences: []sequenceReport{},
+	Warnings:  []string{},
+	Outcome:   outcomeFailed,
+}
+
+var warningsMu sync.Mutex
+
+// warn prints a warning and keeps it for the report. Tables are described
+// again for every batch, so the same warning may come more than once.
+func warn(message string) {
+	progressLine.clear()
+	fmt.Println("  > " + message)
+
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	for _, w := range report.Warnings {
+		if w == message {
+			return
+		}
+	}
+	report.Warnings = append(report.Warnings, message)
+}
+
+func (r *migrationReport) setStats(stats []tableStats, sequences []sequence) {
+	r.Tables = make([]tableReport, len(stats))
+	for i, s := range stats {
+		r.Tables[i] = tableReport{
+			Name:      s.table,
+			Read:      s.read,
+			Written:   s.written,
+			Skipped:   s.skipped,
+			Updated:   s.updated,
+			Conflicts: s.conflicts,
+			Seconds:   s.duration.Seconds(),
+		}
+	}
+
+	r.Sequences = make([]sequenceReport, len(sequences))
+	for i, s := range sequences {
+		r.Sequences[i] = sequenceReport{
+			Name:   s.Name,
+			Table:  s.Table,
+			Column: s.Column,
+			Kind:   s.kind,
+			Old:    s.old,
+			New:    s.new,
+		}
+	}
+}
+
+func (r *migrationReport) write(path string) {
+	r.Finished = time.Now()
+	r.Seconds = r.Finished.Sub(r.Started).Seconds()
+
+	contents, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(path, append(contents, '\n'), 0644)
+	}
+	if err != nil {
+		fmt.Println("error writing report to "+path, err)
+		return
+	}
+	fmt.Println("  > report written to " + path + ".")
+}
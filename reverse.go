@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kr/pretty"
+)
+
+// migrateBack creates a new sqlite database with the schema for the postgres
+// db version and copies every table from postgres into it.
+func migrateBack(sqlitePath string, postgres string) int {
+	if _, err := os.Stat(sqlitePath); err == nil {
+		return fail(exitUsage, sqlitePath+" already exists, refusing to overwrite it.")
+	}
+
+	fmt.Println("  > connecting to postgres and sqlite.")
+
+	pg, err = sqlx.Connect("postgres", postgres)
+	if err != nil {
+		return fail(exitConnection, "postgres connection error: "+err.Error())
+	}
+
+	var dbversion int
+	if err := pg.Get(&dbversion, "SELECT version FROM version"); err != nil {
+		return fail(exitVersion, "error fetching postgres db version: "+err.Error())
+	}
+	rel := releaseFor(dbversion)
+	if rel == nil {
+		return fail(exitVersion, fmt.Sprintf("db version %d is not supported. supported versions: %s", dbversion, supportedVersions()))
+	}
+	ddl, ok := schemas[dbversion]
+	if !ok {
+		return fail(exitSchema, fmt.Sprintf("mcldsp doesn't know the schema for db version %d, can't create the sqlite database.", dbversion))
+	}
+
+	var chtlcsigns int
+	if err := pg.Get(&chtlcsigns, "SELECT count(*) FROM htlc_sigs"); err != nil {
+		return fail(exitSchema, "error counting htlc_sigs: "+err.Error())
+	}
+	if chtlcsigns != 0 {
+		warn(fmt.Sprintf("htlc_sigs has %d signatures of pending htlcs, they will be copied too.", chtlcsigns))
+	}
+
+	sqlt, err = sqlx.Connect("sqlite3", sqlitePath)
+	if err != nil {
+		return fail(exitConnection, "sqlite connection error: "+err.Error())
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			// don't leave a half-made database behind
+			sqlt.Close()
+			os.Remove(sqlitePath)
+		}
+	}()
+	lite, err = sqlt.Beginx()
+	if err != nil {
+		return fail(exitConnection, "sqlite transaction error: "+err.Error())
+	}
+	defer lite.Rollback()
+
+	fmt.Printf("  > creating the sqlite tables for db version %d.\n", dbversion)
+	if _, err := lite.Exec(sqliteSchema(ddl)); err != nil {
+		return fail(exitSchema, "error creating sqlite schema: "+err.Error())
+	}
+
+	fmt.Println("  > moving data from postgres to sqlite.")
+
+	pgx, err := pg.Beginx()
+	if err != nil {
+		return fail(exitConnection, err.Error())
+	}
+	defer pgx.Rollback()
+
+	var stats []tableStats
+	for _, t := range append([]table{{"vars", "name"}}, rel.tables...) {
+		s, err := copyRowsBack(pgx, t)
+		if err != nil {
+			return fail(exitCopy, "error copying "+t.name+": "+err.Error())
+		}
+		stats = append(stats, s)
+	}
+
+	// make sqlite hand out ids after the ones postgres already gave
+	sequences, err := discoverSequences(pgx, rel.tables)
+	if err != nil {
+		return fail(exitSequence, "error discovering sequences: "+err.Error())
+	}
+	for _, seq := range sequences {
+		value, err := sequenceValue(pgx, seq.Name)
+		if err != nil {
+			return fail(exitSequence, "error reading "+seq.Name+": "+err.Error())
+		}
+		if value <= 0 {
+			continue
+		}
+
+		_, err = lite.Exec(`DELETE FROM sqlite_sequence WHERE name = ?`, seq.Table)
+		if err == nil {
+			_, err = lite.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)`, seq.Table, value)
+		}
+		if err != nil {
+			return fail(exitSequence, "error setting sqlite_sequence for "+seq.Table+": "+err.Error())
+		}
+	}
+
+	if err := lite.Commit(); err != nil {
+		return fail(exitCommit, "error on final commit: "+err.Error())
+	}
+	committed = true
+
+	printStats(stats)
+	fmt.Println("  > all data moved to " + sqlitePath + ".")
+	return exitOK
+}
+
+func copyRowsBack(pgx *sqlx.Tx, t table) (stats tableStats, err error) {
+	stats.table = t.name
+
+	columns, err := describeTable(pgx, t.name)
+	if err != nil {
+		return stats, err
+	}
+
+	columnnames := make([]string, len(columns))
+	valuelabels := make([]string, len(columns))
+	values := make([]interface{}, len(columns))
+	for i, col := range columns {
+		columnnames[i] = col.Name
+		valuelabels[i] = "?"
+	}
+
+	insert, err := lite.Preparex(`INSERT INTO ` + t.name + ` (` + strings.Join(columnnames, ",") + `)
+VALUES (` + strings.Join(valuelabels, ",") + `)`)
+	if err != nil {
+		fmt.Println("error preparing insert on '"+t.name+"'", err)
+		return stats, err
+	}
+	defer insert.Close()
+
+	rows, err := pgx.Query(`SELECT ` + strings.Join(columnnames, ",") + ` FROM ` + t.name)
+	if err != nil {
+		fmt.Println("error selecting "+t.name, err)
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		targets := make([]interface{}, len(columns))
+		for i, col := range columns {
+			targets[i] = col.scanTarget()
+		}
+		if err := rows.Scan(targets...); err != nil {
+			pretty.Log(rowDump(columns, targets))
+			fmt.Println("error scanning "+t.name+" row", err)
+			return stats, err
+		}
+		stats.read++
+
+		for i := range targets {
+			values[i] = reflect.Indirect(reflect.ValueOf(targets[i])).Interface()
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			pretty.Log(rowDump(columns, targets))
+			fmt.Println("error inserting on '" + t.name + "': " + err.Error())
+			return stats, err
+		}
+		stats.written++
+	}
+
+	return stats, rows.Err()
+}
+
+var (
+	serialColumn = regexp.MustCompile(`^\s+(\w+) BIGSERIAL,$`)
+	primaryKey   = regexp.MustCompile(`^\s+PRIMARY KEY \((\w+)\),?$`)
+)
+
+// sqliteSchema translates our postgres DDL to sqlite the opposite way
+// lightningd does. Serial primary keys become AUTOINCREMENT so their counters
+// live in sqlite_sequence.
+func sqliteSchema(ddl string) string {
+	statements := strings.Split(ddl, ";")
+	for s, statement := range statements {
+		lines := strings.Split(statement, "\n")
+
+		serials := make(map[string]int)
+		for i, line := range lines {
+			if match := serialColumn.FindStringSubmatch(line); match != nil {
+				serials[match[1]] = i
+			}
+		}
+
+		drop := make(map[int]bool)
+		for i, line := range lines {
+			if match := primaryKey.FindStringSubmatch(line); match != nil {
+				if serial, ok := serials[match[1]]; ok {
+					lines[serial] = strings.Replace(lines[serial], "BIGSERIAL", "INTEGER PRIMARY KEY AUTOINCREMENT", 1)
+					drop[i] = true
+				}
+			}
+		}
+
+		var kept []string
+		for i, line := range lines {
+			if !drop[i] {
+				kept = append(kept, line)
+			} else if !strings.HasSuffix(line, ",") && len(kept) > 0 {
+				// the line before the closing parenthesis can't end with a comma
+				kept[len(kept)-1] = strings.TrimSuffix(kept[len(kept)-1], ",")
+			}
+		}
+
+		statements[s] = strings.Join(kept, "\n")
+	}
+
+	return strings.NewReplacer(
+		"BIGSERIAL", "INTEGER",
+		"BIGINT", "INTEGER",
+		"BYTEA", "BLOB",
+	).Replace(strings.Join(statements, ";"))
+}
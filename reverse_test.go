@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSqliteSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		ddl    string
+		sqlite string
+	}{
+		{
+			name: "serial primary key becomes autoincrement",
+			ddl: `
+CREATE TABLE peers (
+  id BIGSERIAL,
+  node_id BYTEA,
+  PRIMARY KEY (id)
+);`,
+			sqlite: `
+CREATE TABLE peers (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  node_id BLOB
+);`,
+		},
+		{
+			name: "primary key in the middle keeps the commas",
+			ddl: `
+CREATE TABLE peers (
+  id BIGSERIAL,
+  PRIMARY KEY (id),
+  node_id BYTEA
+);`,
+			sqlite: `
+CREATE TABLE peers (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  node_id BLOB
+);`,
+		},
+		{
+			name: "serial without primary key",
+			ddl: `
+CREATE TABLE funding (
+  channel_id BIGSERIAL,
+  amount BIGINT,
+  PRIMARY KEY (channel_id, amount)
+);`,
+			sqlite: `
+CREATE TABLE funding (
+  channel_id INTEGER,
+  amount INTEGER,
+  PRIMARY KEY (channel_id, amount)
+);`,
+		},
+		{
+			name: "other tables untouched",
+			ddl: `
+CREATE TABLE vars (
+  name VARCHAR(32),
+  PRIMARY KEY (name)
+);`,
+			sqlite: `
+CREATE TABLE vars (
+  name VARCHAR(32),
+  PRIMARY KEY (name)
+);`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqliteSchema(tt.ddl); got != tt.sqlite {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.sqlite)
+			}
+		})
+	}
+}
+
+func TestSqliteSchema162(t *testing.T) {
+	db, err := sqlx.Connect("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(sqliteSchema(schema162)); err != nil {
+		t.Fatalf("sqlite doesn't take the schema: %s", err)
+	}
+
+	for _, copied := range append([]table{{"vars", "name"}}, releaseFor(162).tables...) {
+		var columns []string
+		if err := db.Select(&columns, `SELECT name FROM pragma_table_info('`+copied.name+`')`); err != nil {
+			t.Fatal(err)
+		}
+		if len(columns) == 0 {
+			t.Errorf("%s is missing on sqlite", copied.name)
+		}
+	}
+
+	// ids handed out by postgres continue through sqlite_sequence
+	for _, name := range []string{"channels", "peers", "payments"} {
+		var sql string
+		if err := db.Get(&sql, `SELECT sql FROM sqlite_master WHERE name = ?`, name); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(sql, "id INTEGER PRIMARY KEY AUTOINCREMENT") {
+			t.Errorf("%s id is not autoincrement: %s", name, sql)
+		}
+	}
+}
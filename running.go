@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// lightningdRunning looks for signs that a lightningd is still using the sqlite
+// database at path and describes them. lightningd keeps its database next to
+// its pid file and RPC socket, so those are looked for in the same directory
+// and in the one above it (the network directory and the lightning directory).
+func lightningdRunning(path string) (signs []string) {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+
+	if locked, err := sqliteLocked(path); err != nil {
+		signs = append(signs, "can't check the sqlite lock: "+err.Error())
+	} else if locked {
+		signs = append(signs, path+" is locked by another process")
+	}
+
+	dir := filepath.Dir(path)
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		pidfiles, _ := filepath.Glob(filepath.Join(d, "lightningd*.pid"))
+		for _, pidfile := range pidfiles {
+			if pid, alive := pidAlive(pidfile); alive {
+				signs = append(signs, fmt.Sprintf("%s says lightningd is running as pid %d", pidfile, pid))
+			}
+		}
+
+		socket := filepath.Join(d, "lightning-rpc")
+		if conn, err := net.DialTimeout("unix", socket, time.Second); err == nil {
+			conn.Close()
+			signs = append(signs, socket+" is accepting connections")
+		}
+	}
+
+	return signs
+}
+
+// sqliteLocked tries to take an exclusive lock on the sqlite database, which
+// fails while someone else is reading or writing it.
+func sqliteLocked(path string) (bool, error) {
+	db, err := sqlx.Connect("sqlite3", "file:"+path+"?_busy_timeout=100")
+	if err != nil {
+		return isLocked(err), errIfNotLocked(err)
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(context.Background(), "BEGIN EXCLUSIVE"); err != nil {
+		return isLocked(err), errIfNotLocked(err)
+	}
+	_, err = conn.ExecContext(context.Background(), "ROLLBACK")
+	return false, err
+}
+
+func isLocked(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+func errIfNotLocked(err error) error {
+	if isLocked(err) {
+		return nil
+	}
+	return err
+}
+
+// pidAlive reads a pid file and tells if that process exists.
+func pidAlive(pidfile string) (int, bool) {
+	contents, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+
+	// signal 0 only checks the process is there. EPERM means it is, but
+	// belongs to another user.
+	err = syscall.Kill(pid, 0)
+	return pid, err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// column is a column that exists on both sides, with the type each database
+// declares for it.
+type column struct {
+	Name     string
+	LiteType string
+	PGType   string
+
+	// Secret columns are masked in diagnostic output, see secretColumns.
+	Secret bool
+}
+
+// scanTarget returns a pointer suitable for scanning a sqlite value that will
+// be written to this column on postgres.
+func (c column) scanTarget() interface{} {
+	switch c.PGType {
+	case "bytea":
+		return new(sqlblob)
+	case "smallint", "integer", "bigint":
+		return new(sql.NullInt64)
+	case "boolean":
+		return new(sql.NullBool)
+	case "real", "double precision", "numeric":
+		return new(sql.NullFloat64)
+	default:
+		return new(sql.NullString)
+	}
+}
+
+func describeTable(pgx *sqlx.Tx, tableName string) (columns []column, err error) {
+	liteColumns, err := describeLiteTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pgInfo []struct {
+		Name     string `db:"column_name"`
+		DataType string `db:"data_type"`
+	}
+	err = pgx.Select(&pgInfo, `
+SELECT column_name, data_type FROM information_schema.columns
+WHERE table_schema = 'public' AND table_name = $1
+ORDER BY ordinal_position
+    `, tableName)
+	if err != nil {
+		fmt.Println("error reading postgres columns for "+tableName, err)
+		return nil, err
+	}
+	pgColumns := make([]column, len(pgInfo))
+	for i, info := range pgInfo {
+		pgColumns[i] = column{Name: info.Name, PGType: info.DataType}
+	}
+
+	return matchColumns(tableName, liteColumns, pgColumns)
+}
+
+// describeLiteTable lists the columns of a sqlite table, with only Name and
+// LiteType set.
+func describeLiteTable(tableName string) (columns []column, err error) {
+	var liteInfo []struct {
+		Cid     int            `db:"cid"`
+		Name    string         `db:"name"`
+		Type    string         `db:"type"`
+		NotNull bool           `db:"notnull"`
+		Default sql.NullString `db:"dflt_value"`
+		PK      int            `db:"pk"`
+	}
+	err = lite.Select(&liteInfo, `PRAGMA table_info(`+tableName+`)`)
+	if err != nil {
+		fmt.Println("error reading sqlite columns for "+tableName, err)
+		return nil, err
+	}
+
+	columns = make([]column, len(liteInfo))
+	for i, info := range liteInfo {
+		columns[i] = column{Name: info.Name, LiteType: info.Type}
+	}
+	return columns, nil
+}
+
+// matchColumns pairs the columns of a table on sqlite with the ones on
+// postgres (which only have PGType set), in postgres order, and warns about
+// the ones that exist on a single side.
+func matchColumns(tableName string, liteColumns []column, pgColumns []column) (columns []column, err error) {
+	liteTypes := make(map[string]string, len(liteColumns))
+	for _, col := range liteColumns {
+		liteTypes[col.Name] = col.LiteType
+	}
+
+	onPG := make(map[string]bool, len(pgColumns))
+	for _, col := range pgColumns {
+		onPG[col.Name] = true
+
+		liteType, ok := liteTypes[col.Name]
+		if !ok {
+			warn(fmt.Sprintf("%s.%s exists only on postgres, it will be left with its default value.",
+				tableName, col.Name))
+			continue
+		}
+
+		columns = append(columns, column{
+			Name:     col.Name,
+			LiteType: liteType,
+			PGType:   col.PGType,
+			Secret:   isSecret(tableName, col.Name),
+		})
+	}
+
+	for _, col := range liteColumns {
+		if !onPG[col.Name] {
+			warn(fmt.Sprintf("%s.%s exists only on sqlite, it will not be copied.",
+				tableName, col.Name))
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s has no columns in common between sqlite and postgres", tableName)
+	}
+
+	return columns, nil
+}
+
+// rowDump turns scanned values into something readable for error output, with
+// secrets masked.
+func rowDump(columns []column, targets []interface{}) map[string]interface{} {
+	dump := make(map[string]interface{}, len(columns))
+	for i, col := range columns {
+		if col.Secret {
+			dump[col.Name] = maskValue(targets[i])
+			continue
+		}
+		dump[col.Name] = reflect.Indirect(reflect.ValueOf(targets[i])).Interface()
+	}
+	return dump
+}
+
+// keyDump describes a row by its unique key, like "channel_id=3, hstate=1".
+func keyDump(t table, columns []column, targets []interface{}) string {
+	var parts []string
+	for _, key := range t.keys() {
+		for i, col := range columns {
+			if col.Name == key && col.Secret {
+				parts = append(parts, key+"="+fmt.Sprint(maskValue(targets[i])))
+			} else if col.Name == key {
+				parts = append(parts, key+"="+formatValue(targets[i]))
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatValue prints a scanned value the way it would be written in SQL.
+func formatValue(target interface{}) string {
+	switch v := target.(type) {
+	case *sqlblob:
+		if *v == nil {
+			return "NULL"
+		}
+		return v.String()
+	case *sql.NullInt64:
+		if !v.Valid {
+			return "NULL"
+		}
+		return strconv.FormatInt(v.Int64, 10)
+	case *sql.NullBool:
+		if !v.Valid {
+			return "NULL"
+		}
+		return strconv.FormatBool(v.Bool)
+	case *sql.NullFloat64:
+		if !v.Valid {
+			return "NULL"
+		}
+		return strconv.FormatFloat(v.Float64, 'g', -1, 64)
+	case *sql.NullString:
+		if !v.Valid {
+			return "NULL"
+		}
+		return strconv.Quote(v.String)
+	default:
+		return fmt.Sprint(target)
+	}
+}
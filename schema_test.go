@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   string
+		lite    []column
+		pg      []column
+		columns []column
+		err     bool
+	}{
+		{
+			name:  "postgres order and both types",
+			table: "peers",
+			lite:  []column{{Name: "address", LiteType: "TEXT"}, {Name: "id", LiteType: "INTEGER"}},
+			pg:    []column{{Name: "id", PGType: "bigint"}, {Name: "address", PGType: "text"}},
+			columns: []column{
+				{Name: "id", LiteType: "INTEGER", PGType: "bigint"},
+				{Name: "address", LiteType: "TEXT", PGType: "text"},
+			},
+		},
+		{
+			name:    "columns on a single side are left out",
+			table:   "peers",
+			lite:    []column{{Name: "id", LiteType: "INTEGER"}, {Name: "old", LiteType: "TEXT"}},
+			pg:      []column{{Name: "id", PGType: "bigint"}, {Name: "new", PGType: "text"}},
+			columns: []column{{Name: "id", LiteType: "INTEGER", PGType: "bigint"}},
+		},
+		{
+			name:  "secrets are marked",
+			table: "htlc_sigs",
+			lite:  []column{{Name: "channelid", LiteType: "INTEGER"}, {Name: "signature", LiteType: "BLOB"}},
+			pg:    []column{{Name: "channelid", PGType: "integer"}, {Name: "signature", PGType: "bytea"}},
+			columns: []column{
+				{Name: "channelid", LiteType: "INTEGER", PGType: "integer"},
+				{Name: "signature", LiteType: "BLOB", PGType: "bytea", Secret: true},
+			},
+		},
+		{
+			name:  "nothing in common",
+			table: "peers",
+			lite:  []column{{Name: "a", LiteType: "TEXT"}},
+			pg:    []column{{Name: "b", PGType: "text"}},
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, err := matchColumns(tt.table, tt.lite, tt.pg)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v", err)
+			}
+			if !reflect.DeepEqual(columns, tt.columns) {
+				t.Errorf("columns = %+v, want %+v", columns, tt.columns)
+			}
+		})
+	}
+}
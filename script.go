@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kr/pretty"
+)
+
+// writeScript does the migration without a postgres connection: instead of
+// copying rows it writes a SQL script that creates the schema if it isn't
+// there, inserts every row and sets the sequences, all in a single
+// transaction, to be run later with psql.
+func writeScript(sqlitePath string, output string, onConflict string, allowUnknownTables bool) int {
+	fmt.Println("  > connecting to sqlite.")
+
+	sqlt, err = sqlx.Connect("sqlite3", sqlitePath)
+	if err != nil {
+		return fail(exitConnection, "sqlite connection error: "+err.Error())
+	}
+	lite, err = sqlt.Beginx()
+	if err != nil {
+		return fail(exitConnection, "sqlite transaction error: "+err.Error())
+	}
+	defer lite.Rollback()
+
+	var dbversion int
+	if err := lite.Get(&dbversion, "SELECT version FROM version"); err != nil {
+		return fail(exitVersion, "error fetching sqlite db version: "+err.Error())
+	}
+	rel := releaseFor(dbversion)
+	if rel == nil {
+		return fail(exitVersion, fmt.Sprintf("db version %d is not supported. supported versions: %s", dbversion, supportedVersions()))
+	}
+	ddl, ok := schemas[dbversion]
+	if !ok {
+		return fail(exitSchema, fmt.Sprintf("mcldsp doesn't know the schema for db version %d, it can't write a script for it.", dbversion))
+	}
+
+	unknown, err := checkCoverage(rel)
+	if err != nil {
+		return fail(exitSource, "error listing sqlite tables: "+err.Error())
+	}
+	if len(unknown) > 0 {
+		if !allowUnknownTables {
+			return fail(exitVersion, "sqlite has tables mcldsp doesn't know how to copy: "+strings.Join(unknown, ", ")+" (use -allow-unknown-tables to leave them empty)")
+		}
+		warn("WARNING: leaving unknown tables empty on postgres: " + strings.Join(unknown, ", "))
+	}
+
+	var chtlcsigns int
+	if err := lite.Get(&chtlcsigns, "SELECT count(*) FROM htlc_sigs"); err != nil {
+		return fail(exitSource, "error counting htlc_sigs: "+err.Error())
+	}
+	if chtlcsigns != 0 {
+		warn(fmt.Sprintf("htlc_sigs has %d signatures of pending htlcs, they will be copied too.", chtlcsigns))
+	}
+
+	report.Source.Version = dbversion
+	report.Target.Script = output
+	for _, f := range rel.fixes {
+		result, err := lite.Exec(f.query)
+		if err != nil {
+			return fail(exitSource, "error applying fix '"+f.description+"': "+err.Error())
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			warn(fmt.Sprintf("%s (%d rows)", f.description, affected))
+		}
+	}
+
+	schema := parseSchema(ddl)
+	tables, deferred := sortTables(rel.tables, schema.foreignKeys)
+
+	// write somewhere else first so a failure doesn't leave half a script
+	// where the real one should be
+	file, err := os.Create(output + ".tmp")
+	if err != nil {
+		return fail(exitFailure, "error creating "+output+": "+err.Error())
+	}
+	defer os.Remove(output + ".tmp")
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	fmt.Printf("  > writing %s.\n", output)
+	fmt.Fprintf(w, `-- generated by mcldsp from %s (db version %d)
+-- apply with: psql -f %s <postgres_dsn>
+
+\set ON_ERROR_STOP on
+SET standard_conforming_strings = on;
+SET client_encoding = 'UTF8';
+
+BEGIN;
+
+-- the schema, unless it is already there
+DO $mcldsp$
+BEGIN
+  IF to_regclass('public.version') IS NULL THEN
+    EXECUTE $ddl$%s$ddl$;
+  END IF;
+END
+$mcldsp$;
+
+DO $mcldsp$
+BEGIN
+  IF (SELECT version FROM version) IS DISTINCT FROM %d THEN
+    RAISE EXCEPTION 'postgres db version is not %d';
+  END IF;
+END
+$mcldsp$;
+
+`, sqlitePath, dbversion, output, ddl, dbversion, dbversion)
+
+	for _, fk := range deferred {
+		fmt.Fprintf(w, "ALTER TABLE %s ALTER CONSTRAINT %s DEFERRABLE;\nSET CONSTRAINTS %s DEFERRED;\n",
+			fk.Table, fk.Name, fk.Name)
+	}
+
+	// vars may already be on postgres, as lightningd creates some of them
+	stats := make([]tableStats, 0, len(tables)+1)
+	for i, t := range append([]table{{"vars", "name"}}, tables...) {
+		mode := onConflict
+		if i == 0 {
+			mode = conflictUpdate
+		}
+		s, err := scriptRows(w, t, schema.tables[t.name], mode)
+		if err != nil {
+			return fail(exitCopy, "error writing "+t.name+": "+err.Error())
+		}
+		stats = append(stats, s)
+	}
+
+	for _, fk := range deferred {
+		fmt.Fprintf(w, "SET CONSTRAINTS %s IMMEDIATE;\nALTER TABLE %s ALTER CONSTRAINT %s NOT DEFERRABLE;\n",
+			fk.Name, fk.Table, fk.Name)
+	}
+
+	// same as setSequence: move each sequence to the highest id, never back.
+	// postgres knows which sequence a column uses, so its name isn't guessed
+	fmt.Fprintf(w, "\n-- sequences\n")
+	for _, t := range tables {
+		for _, col := range schema.tables[t.name].serials {
+			seq := fmt.Sprintf("pg_get_serial_sequence('%s', '%s')", t.name, col)
+			fmt.Fprintf(w, "SELECT setval(%s, max(%s)) FROM %s HAVING max(%s) > coalesce(pg_sequence_last_value(%s), 0);\n",
+				seq, col, t.name, col, seq)
+		}
+	}
+
+	fmt.Fprintf(w, "\nCOMMIT;\n")
+
+	if err := w.Flush(); err != nil {
+		return fail(exitFailure, "error writing "+output+": "+err.Error())
+	}
+	if err := file.Close(); err != nil {
+		return fail(exitFailure, "error writing "+output+": "+err.Error())
+	}
+	if err := os.Rename(output+".tmp", output); err != nil {
+		return fail(exitFailure, "error writing "+output+": "+err.Error())
+	}
+
+	report.Outcome = outcomeScript
+	report.setStats(stats, nil)
+	printStats(stats)
+	fmt.Println("  > script written to " + output + ", apply it with psql -f " + output + ".")
+	return exitOK
+}
+
+// scriptRows writes an INSERT for every row of a sqlite table.
+func scriptRows(w *bufio.Writer, t table, ddl ddlTable, onConflict string) (stats tableStats, err error) {
+	stats.table = t.name
+
+	liteColumns, err := describeLiteTable(t.name)
+	if err != nil {
+		return stats, err
+	}
+	pgColumns := make([]column, len(ddl.order))
+	for i, name := range ddl.order {
+		pgColumns[i] = column{Name: name, PGType: ddl.types[name]}
+	}
+	columns, err := matchColumns(t.name, liteColumns, pgColumns)
+	if err != nil {
+		return stats, err
+	}
+
+	columnnames := make([]string, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i, col := range columns {
+		columnnames[i] = col.Name
+		targets[i] = col.scanTarget()
+	}
+
+	conflictStmt := ""
+	if t.unique != "" {
+		switch onConflict {
+		case conflictSkip:
+			conflictStmt = ` ON CONFLICT (` + t.unique + `) DO NOTHING`
+		case conflictUpdate:
+			conflictStmt = ` ` + conflictUpdateStmt(t, columnnames)
+		}
+	}
+	insert := `INSERT INTO ` + t.name + ` (` + strings.Join(columnnames, ",") + `) VALUES (`
+
+	rows, err := lite.Query(`SELECT ` + strings.Join(columnnames, ",") + ` FROM ` + t.name + ` ORDER BY rowid`)
+	if err != nil {
+		fmt.Println("error selecting "+t.name, err)
+		return stats, err
+	}
+	defer rows.Close()
+
+	start := time.Now()
+	fmt.Fprintf(w, "\n-- %s\n", t.name)
+	if replacedTables[t.name] {
+		fmt.Fprintf(w, "DELETE FROM %s;\n", t.name)
+	}
+	values := make([]string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(targets...); err != nil {
+			pretty.Log(rowDump(columns, targets))
+			fmt.Println("error scanning "+t.name+" row", err)
+			return stats, err
+		}
+		stats.read++
+
+		for i := range targets {
+			values[i] = sqlLiteral(targets[i])
+		}
+		w.WriteString(insert + strings.Join(values, ",") + `)` + conflictStmt + ";\n")
+		stats.written++
+	}
+
+	stats.duration = time.Since(start)
+	return stats, rows.Err()
+}
+
+// sqlLiteral writes a scanned value as a postgres literal.
+func sqlLiteral(target interface{}) string {
+	switch v := target.(type) {
+	case *sqlblob:
+		if *v == nil {
+			return "NULL"
+		}
+		return "'" + v.String() + "'"
+	case *sql.NullFloat64:
+		if v.Valid && (math.IsNaN(v.Float64) || math.IsInf(v.Float64, 0)) {
+			return "'" + strconv.FormatFloat(v.Float64, 'g', -1, 64) + "'"
+		}
+		return formatValue(target)
+	case *sql.NullString:
+		if !v.Valid {
+			return "NULL"
+		}
+		return "'" + strings.ReplaceAll(v.String, "'", "''") + "'"
+	default:
+		return formatValue(target)
+	}
+}
+
+// ddlTable is what parseSchema learns about a table from our DDL.
+type ddlTable struct {
+	order   []string
+	types   map[string]string // like information_schema's data_type
+	serials []string
+}
+
+type ddlSchema struct {
+	tables      map[string]ddlTable
+	foreignKeys []foreignKey
+}
+
+var (
+	createTable  = regexp.MustCompile(`^CREATE TABLE (\w+) \(`)
+	columnLine   = regexp.MustCompile(`^\s+(\w+) (\w+)`)
+	referencesTo = regexp.MustCompile(`REFERENCES (\w+)\(`)
+)
+
+// parseSchema reads the columns and foreign keys out of one of our DDLs, for
+// when there is no postgres to ask.
+func parseSchema(ddl string) ddlSchema {
+	schema := ddlSchema{tables: make(map[string]ddlTable)}
+
+	for _, statement := range strings.Split(ddl, ";") {
+		lines := strings.Split(strings.TrimSpace(statement), "\n")
+		match := createTable.FindStringSubmatch(lines[0])
+		if match == nil {
+			continue
+		}
+		name := match[1]
+
+		t := ddlTable{types: make(map[string]string)}
+		for _, line := range lines[1:] {
+			col := columnLine.FindStringSubmatch(line)
+			if col == nil {
+				continue
+			}
+			switch col[1] {
+			case "PRIMARY", "UNIQUE", "FOREIGN", "CONSTRAINT", "CHECK":
+				continue
+			}
+
+			t.order = append(t.order, col[1])
+			switch col[2] {
+			case "BIGSERIAL":
+				t.types[col[1]] = "bigint"
+				t.serials = append(t.serials, col[1])
+			case "BIGINT":
+				t.types[col[1]] = "bigint"
+			case "INTEGER":
+				t.types[col[1]] = "integer"
+			case "BYTEA":
+				t.types[col[1]] = "bytea"
+			case "BOOLEAN":
+				t.types[col[1]] = "boolean"
+			case "REAL":
+				t.types[col[1]] = "real"
+			case "DOUBLE":
+				t.types[col[1]] = "double precision"
+			default:
+				t.types[col[1]] = "text"
+			}
+
+			// postgres names unnamed foreign keys like this
+			if ref := referencesTo.FindStringSubmatch(line); ref != nil {
+				schema.foreignKeys = append(schema.foreignKeys, foreignKey{
+					Name:       name + "_" + col[1] + "_fkey",
+					Table:      name,
+					References: ref[1],
+				})
+			}
+		}
+		schema.tables[name] = t
+	}
+
+	return schema
+}
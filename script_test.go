@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		ddl    string
+		tables map[string]ddlTable
+		fks    []foreignKey
+	}{
+		{
+			name: "types, serials and constraint lines",
+			ddl: `
+CREATE TABLE peers (
+  id BIGSERIAL,
+  node_id BYTEA,
+  address TEXT,
+  PRIMARY KEY (id),
+  UNIQUE (node_id)
+);
+CREATE INDEX peers_idx ON peers (node_id);
+CREATE TABLE things (
+  n INTEGER,
+  big BIGINT,
+  ok BOOLEAN,
+  r REAL,
+  d DOUBLE PRECISION,
+  CONSTRAINT things_n CHECK (n > 0)
+);`,
+			tables: map[string]ddlTable{
+				"peers": {
+					order:   []string{"id", "node_id", "address"},
+					types:   map[string]string{"id": "bigint", "node_id": "bytea", "address": "text"},
+					serials: []string{"id"},
+				},
+				"things": {
+					order: []string{"n", "big", "ok", "r", "d"},
+					types: map[string]string{"n": "integer", "big": "bigint", "ok": "boolean",
+						"r": "real", "d": "double precision"},
+				},
+			},
+		},
+		{
+			name: "foreign keys named like postgres does",
+			ddl: `
+CREATE TABLE channels (
+  id BIGSERIAL,
+  peer_id BIGINT REFERENCES peers(id) ON DELETE CASCADE,
+  PRIMARY KEY (id)
+);`,
+			tables: map[string]ddlTable{
+				"channels": {
+					order:   []string{"id", "peer_id"},
+					types:   map[string]string{"id": "bigint", "peer_id": "bigint"},
+					serials: []string{"id"},
+				},
+			},
+			fks: []foreignKey{{Name: "channels_peer_id_fkey", Table: "channels", References: "peers"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := parseSchema(tt.ddl)
+			if !reflect.DeepEqual(schema.tables, tt.tables) {
+				t.Errorf("tables = %#v, want %#v", schema.tables, tt.tables)
+			}
+			if !reflect.DeepEqual(schema.foreignKeys, tt.fks) {
+				t.Errorf("foreign keys = %v, want %v", schema.foreignKeys, tt.fks)
+			}
+		})
+	}
+}
+
+func TestParseSchema162(t *testing.T) {
+	schema := parseSchema(schema162)
+
+	// every table is copied, skipped or vars
+	known := map[string]bool{"vars": true}
+	for _, table := range releaseFor(162).tables {
+		known[table.name] = true
+	}
+	for _, name := range releaseFor(162).skip {
+		known[name] = true
+	}
+	for name := range schema.tables {
+		if !known[name] {
+			t.Errorf("%s is neither copied nor skipped", name)
+		}
+	}
+	for _, table := range releaseFor(162).tables {
+		if len(schema.tables[table.name].order) == 0 {
+			t.Errorf("%s has no columns", table.name)
+		}
+		for _, key := range table.keys() {
+			if _, ok := schema.tables[table.name].types[key]; !ok {
+				t.Errorf("%s has no column %s for its key", table.name, key)
+			}
+		}
+	}
+	if len(schema.foreignKeys) != 21 {
+		t.Errorf("got %d foreign keys, want 21", len(schema.foreignKeys))
+	}
+
+	tests := []struct {
+		table   string
+		order   []string
+		serials []string
+	}{
+		{"htlc_sigs", []string{"channelid", "signature"}, nil},
+		{"vars", []string{"name", "val", "intval", "blobval"}, nil},
+		{"peers", []string{"id", "node_id", "address"}, []string{"id"}},
+	}
+	for _, tt := range tests {
+		got := schema.tables[tt.table]
+		if !reflect.DeepEqual(got.order, tt.order) || !reflect.DeepEqual(got.serials, tt.serials) {
+			t.Errorf("%s: order %v, serials %v, want %v, %v", tt.table, got.order, got.serials, tt.order, tt.serials)
+		}
+	}
+}
+
+func TestSQLLiteral(t *testing.T) {
+	blob := sqlblob{0xab, 0x01}
+	tests := []struct {
+		name    string
+		target  interface{}
+		literal string
+	}{
+		{"null blob", new(sqlblob), "NULL"},
+		{"blob", &blob, `'\xab01'`},
+		{"null string", &sql.NullString{}, "NULL"},
+		{"quotes in string", &sql.NullString{String: "it's", Valid: true}, `'it''s'`},
+		{"integer", &sql.NullInt64{Int64: 42, Valid: true}, "42"},
+		{"null integer", &sql.NullInt64{}, "NULL"},
+		{"infinity", &sql.NullFloat64{Float64: math.Inf(1), Valid: true}, "'+Inf'"},
+		{"nan", &sql.NullFloat64{Float64: math.NaN(), Valid: true}, "'NaN'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sqlLiteral(tt.target); got != tt.literal {
+				t.Errorf("got %s, want %s", got, tt.literal)
+			}
+		})
+	}
+}
+
+func TestSortTablesSchema162(t *testing.T) {
+	tables := releaseFor(162).tables
+	fks := parseSchema(schema162).foreignKeys
+
+	sorted, deferred := sortTables(tables, fks)
+	if len(sorted) != len(tables) {
+		t.Fatalf("got %d tables, want %d", len(sorted), len(tables))
+	}
+	if len(deferred) != 0 {
+		t.Errorf("schema 162 has no cycles, but got deferred %v", deferred)
+	}
+
+	position := make(map[string]int, len(sorted))
+	for i, table := range sorted {
+		position[table.name] = i
+	}
+	for _, fk := range fks {
+		from, ok1 := position[fk.Table]
+		to, ok2 := position[fk.References]
+		if ok1 && ok2 && to > from {
+			t.Errorf("%s is copied before %s", fk.Table, fk.References)
+		}
+	}
+}
+
+func TestConflictUpdateStmtSchema162(t *testing.T) {
+	schema := parseSchema(schema162)
+	for _, table := range releaseFor(162).tables {
+		if table.unique == "" {
+			continue
+		}
+
+		stmt := conflictUpdateStmt(table, schema.tables[table.name].order)
+		for _, key := range table.keys() {
+			if strings.Contains(stmt, " "+key+"=") || strings.Contains(stmt, ","+key+"=") {
+				t.Errorf("%s: key column %s is updated: %s", table.name, key, stmt)
+			}
+		}
+		if !strings.HasPrefix(stmt, "ON CONFLICT ("+table.unique+") DO ") {
+			t.Errorf("%s: %s", table.name, stmt)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// secretColumns hold keys, preimages and signatures that must not end up in
+// terminal scrollback or logs.
+var secretColumns = map[string]bool{
+	"channel_htlcs.payment_key":          true,
+	"channel_htlcs.shared_secret":        true,
+	"channels.last_tx":                   true,
+	"channels.last_sig":                  true,
+	"channel_funding_inflights.last_tx":  true,
+	"channel_funding_inflights.last_sig": true,
+	"htlc_sigs.signature":                true,
+	"invoices.payment_key":               true,
+	"payments.payment_preimage":          true,
+	"payments.path_secrets":              true,
+	"shachain_known.hash":                true,
+}
+
+// logSecrets turns off masking, set by -unsafe-log-secrets.
+var logSecrets bool
+
+func isSecret(tableName, columnName string) bool {
+	return secretColumns[tableName+"."+columnName] && !logSecrets
+}
+
+// maskValue hides a secret value, leaving only enough to tell values apart,
+// like "\xab12…(32 bytes)".
+func maskValue(target interface{}) interface{} {
+	switch v := target.(type) {
+	case *sqlblob:
+		if *v == nil {
+			return "NULL"
+		}
+		s := v.String()
+		if len(s) > 6 {
+			s = s[:6]
+		}
+		return fmt.Sprintf("%s…(%d bytes)", s, len(*v))
+	case *sql.NullString:
+		if !v.Valid {
+			return "NULL"
+		}
+		s := v.String
+		if len(s) > 4 {
+			s = s[:4]
+		}
+		return fmt.Sprintf("%q…(%d bytes)", s, len(v.String))
+	default:
+		return "(secret)"
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestMaskValue(t *testing.T) {
+	short := sqlblob{0xab}
+	long := sqlblob{0xab, 0x12, 0x34, 0x56}
+	tests := []struct {
+		name   string
+		target interface{}
+		masked interface{}
+	}{
+		{"null blob", new(sqlblob), "NULL"},
+		{"short blob", &short, `\xab…(1 bytes)`},
+		{"long blob", &long, `\xab12…(4 bytes)`},
+		{"null string", &sql.NullString{}, "NULL"},
+		{"short string", &sql.NullString{String: "ab", Valid: true}, `"ab"…(2 bytes)`},
+		{"long string", &sql.NullString{String: "abcdefgh", Valid: true}, `"abcd"…(8 bytes)`},
+		{"anything else", &sql.NullInt64{Int64: 42, Valid: true}, "(secret)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maskValue(tt.target); got != tt.masked {
+				t.Errorf("got %v, want %v", got, tt.masked)
+			}
+		})
+	}
+}
+
+func TestIsSecret(t *testing.T) {
+	defer func(previous bool) { logSecrets = previous }(logSecrets)
+
+	tests := []struct {
+		table      string
+		column     string
+		logSecrets bool
+		secret     bool
+	}{
+		{"htlc_sigs", "signature", false, true},
+		{"htlc_sigs", "signature", true, false},
+		{"htlc_sigs", "channelid", false, false},
+		{"payments", "payment_preimage", false, true},
+		{"invoices", "payment_hash", false, false},
+	}
+
+	for _, tt := range tests {
+		logSecrets = tt.logSecrets
+		if got := isSecret(tt.table, tt.column); got != tt.secret {
+			t.Errorf("isSecret(%s, %s) with logSecrets=%v = %v", tt.table, tt.column, tt.logSecrets, got)
+		}
+	}
+}
+
+func TestSecretColumnsSchema162(t *testing.T) {
+	schema := parseSchema(schema162)
+	for name := range secretColumns {
+		found := false
+		for tableName, table := range schema.tables {
+			for _, column := range table.order {
+				if tableName+"."+column == name {
+					found = true
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s is not a column of schema 162", name)
+		}
+	}
+}
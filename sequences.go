@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// kinds of automatic id columns.
+const (
+	kindSequence        = "sequence"
+	kindUniqueRowid     = "unique_rowid()"
+	kindVirtualSequence = "virtual sequence"
+)
+
+// sequence is a postgres sequence owned by a column of a copied table. On
+// CockroachDB the column may be fed by something else, as told by kind, and
+// then there is nothing to reset.
+type sequence struct {
+	Name   string `db:"sequence_name"`
+	Table  string `db:"table_name"`
+	Column string `db:"column_name"`
+	kind   string
+
+	// old and new are the sequence's last value before and after setSequence.
+	old int64
+	new int64
+}
+
+func (s sequence) String() string {
+	if s.kind != kindSequence {
+		return fmt.Sprintf("%-40s %s, no reset needed", s.Table+"."+s.Column, s.kind)
+	}
+	if s.old == s.new {
+		return fmt.Sprintf("%-40s %d (untouched)", s.Name, s.old)
+	}
+	return fmt.Sprintf("%-40s %d -> %d", s.Name, s.old, s.new)
+}
+
+// discoverSequences finds every sequence owned by a column of the given tables,
+// which is what a SERIAL or IDENTITY column gets.
+func discoverSequences(pgx *sqlx.Tx, tables []table) (sequences []sequence, err error) {
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.name
+	}
+
+	err = pgx.Select(&sequences, `
+SELECT quote_ident(sn.nspname) || '.' || quote_ident(s.relname) AS sequence_name,
+       t.relname AS table_name,
+       a.attname AS column_name
+FROM pg_class s
+JOIN pg_namespace sn ON sn.oid = s.relnamespace
+JOIN pg_depend d ON d.objid = s.oid
+                AND d.classid = 'pg_class'::regclass
+                AND d.refclassid = 'pg_class'::regclass
+                AND d.deptype IN ('a', 'i')
+JOIN pg_class t ON t.oid = d.refobjid
+JOIN pg_namespace tn ON tn.oid = t.relnamespace
+JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = d.refobjsubid
+WHERE s.relkind = 'S' AND tn.nspname = 'public' AND t.relname = ANY($1)
+ORDER BY t.relname, a.attname
+    `, pq.Array(names))
+	if err != nil {
+		fmt.Println("error discovering sequences", err)
+		return nil, err
+	}
+	for i := range sequences {
+		sequences[i].kind = kindSequence
+	}
+
+	return sequences, nil
+}
+
+var nextvalDefault = regexp.MustCompile(`nextval\('([^']+)'`)
+
+// discoverCockroachSequences looks at the defaults of the columns of the given
+// tables. Depending on serial_normalization CockroachDB turns SERIAL into
+// unique_rowid(), a virtual sequence (unique_rowid() behind a sequence's name)
+// or a real sequence, and only the last one has to be moved.
+func discoverCockroachSequences(pgx *sqlx.Tx, tables []table) (sequences []sequence, err error) {
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.name
+	}
+
+	var defaults []struct {
+		Table   string `db:"table_name"`
+		Column  string `db:"column_name"`
+		Default string `db:"column_default"`
+	}
+	err = pgx.Select(&defaults, `
+SELECT table_name, column_name, column_default FROM information_schema.columns
+WHERE table_schema = 'public' AND table_name = ANY($1) AND column_default IS NOT NULL
+ORDER BY table_name, column_name
+    `, pq.Array(names))
+	if err != nil {
+		fmt.Println("error reading column defaults", err)
+		return nil, err
+	}
+
+	for _, d := range defaults {
+		seq := sequence{Table: d.Table, Column: d.Column}
+
+		if strings.Contains(d.Default, "unique_rowid()") {
+			seq.kind = kindUniqueRowid
+		} else if match := nextvalDefault.FindStringSubmatch(d.Default); match != nil {
+			seq.Name = match[1]
+
+			var create struct {
+				Name      string `db:"table_name"`
+				Statement string `db:"create_statement"`
+			}
+			if err := pgx.Get(&create, `SHOW CREATE SEQUENCE `+seq.Name); err != nil {
+				fmt.Println("error inspecting sequence", seq.Name, err)
+				return nil, err
+			}
+			if strings.Contains(strings.ToUpper(create.Statement), "VIRTUAL") {
+				seq.kind = kindVirtualSequence
+			} else {
+				seq.kind = kindSequence
+			}
+		} else {
+			continue
+		}
+
+		sequences = append(sequences, seq)
+	}
+
+	return sequences, nil
+}
+
+// setSequence moves a sequence to the highest value its column has on postgres
+// after the copy, never backwards. On a dry run nothing is set, as setval()
+// can't be rolled back.
+func setSequence(pgx *sqlx.Tx, seq *sequence, dryRun bool) (err error) {
+	if seq.kind != kindSequence {
+		return nil
+	}
+
+	seq.old, err = sequenceValue(pgx, seq.Name)
+	if err != nil {
+		return
+	}
+	seq.new = seq.old
+
+	var maxval int64
+	err = pgx.Get(&maxval, `SELECT coalesce(max(`+seq.Column+`), 0) FROM `+seq.Table)
+	if err != nil {
+		fmt.Println("error fetching maximum value for", seq.Name, seq.Table, seq.Column, err)
+		return
+	}
+
+	if maxval <= seq.old {
+		// all is fine
+		return nil
+	}
+
+	seq.new = maxval
+	if dryRun {
+		return nil
+	}
+
+	_, err = pgx.Exec(`SELECT setval($1, $2)`, seq.Name, maxval)
+	if err != nil {
+		fmt.Println("error setting sequence", seq.Name, err)
+		return
+	}
+
+	return nil
+}
+
+// sequenceValue is the last value a sequence handed out.
+func sequenceValue(pgx *sqlx.Tx, sequenceName string) (int64, error) {
+	var current struct {
+		LastValue int64 `db:"last_value"`
+		IsCalled  bool  `db:"is_called"`
+	}
+	err := pgx.Get(&current, `SELECT last_value, is_called FROM `+sequenceName)
+	if err != nil {
+		fmt.Println("error reading sequence", sequenceName, err)
+		return 0, err
+	}
+	if !current.IsCalled {
+		// nothing was taken from it yet
+		return current.LastValue - 1, nil
+	}
+	return current.LastValue, nil
+}
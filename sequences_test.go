@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSequenceString(t *testing.T) {
+	tests := []struct {
+		name     string
+		sequence sequence
+		want     string
+	}{
+		{
+			name:     "moved",
+			sequence: sequence{Name: "channels_id_seq", kind: kindSequence, old: 1, new: 42},
+			want:     "channels_id_seq 1 -> 42",
+		},
+		{
+			name:     "already past the copied ids",
+			sequence: sequence{Name: "peers_id_seq", kind: kindSequence, old: 7, new: 7},
+			want:     "peers_id_seq 7 (untouched)",
+		},
+		{
+			name:     "unique_rowid",
+			sequence: sequence{Table: "channels", Column: "id", kind: kindUniqueRowid},
+			want:     "channels.id unique_rowid(), no reset needed",
+		},
+		{
+			name:     "virtual sequence",
+			sequence: sequence{Name: "peers_id_seq", Table: "peers", Column: "id", kind: kindVirtualSequence},
+			want:     "peers.id virtual sequence, no reset needed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(strings.Fields(tt.sequence.String()), " ")
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextvalDefault(t *testing.T) {
+	tests := []struct {
+		column   string
+		sequence string
+	}{
+		{"nextval('channels_id_seq'::regclass)", "channels_id_seq"},
+		{"nextval('public.peers_id_seq'::REGCLASS)", "public.peers_id_seq"},
+		{"unique_rowid()", ""},
+		{"0", ""},
+	}
+
+	for _, tt := range tests {
+		var got string
+		if match := nextvalDefault.FindStringSubmatch(tt.column); match != nil {
+			got = match[1]
+		}
+		if got != tt.sequence {
+			t.Errorf("%s: got %q, want %q", tt.column, got, tt.sequence)
+		}
+	}
+}
@@ -11,18 +11,32 @@ type sqlblob []byte
 func (b *sqlblob) Scan(value interface{}) error {
 	switch v := value.(type) {
 	case nil:
+		*b = nil
 		return nil
 	case []byte:
-		*b = sqlblob(v)
+		b.reuse(len(v))
+		*b = append(*b, v...)
 		return nil
 	case string:
-		*b = sqlblob(v)
+		b.reuse(len(v))
+		*b = append(*b, v...)
 		return nil
 	default:
 		return errors.New("sqlblob: value is not binary")
 	}
 }
 
+// reuse empties the blob keeping its memory, so scanning row after row into
+// the same sqlblob doesn't allocate every time. the driver's bytes are copied,
+// as they are only valid until the next scan.
+func (b *sqlblob) reuse(size int) {
+	if *b == nil || cap(*b) < size {
+		*b = make(sqlblob, 0, size)
+	} else {
+		*b = (*b)[:0]
+	}
+}
+
 func (b sqlblob) Value() (driver.Value, error) {
 	if b == nil {
 		return nil, nil
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSqlblobScan(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  sqlblob
+	}{
+		{"null", nil, nil},
+		{"bytes", []byte{1, 2, 3}, sqlblob{1, 2, 3}},
+		{"string", "ab", sqlblob("ab")},
+		{"empty is not null", []byte{}, sqlblob{}},
+	}
+
+	// the same blob is scanned into over and over, like copyRows does
+	b := sqlblob{9, 9, 9, 9, 9}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := b.Scan(tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, tt.want) || (b == nil) != (tt.want == nil) {
+				t.Errorf("got %#v, want %#v", b, tt.want)
+			}
+		})
+	}
+
+	if err := b.Scan(42); err == nil {
+		t.Error("scanning an integer should fail")
+	}
+}
+
+func TestSqlblobScanCopies(t *testing.T) {
+	// the driver reuses its buffer after the next row, the blob must not
+	driverBytes := []byte{1, 2, 3}
+	var b sqlblob
+	b.Scan(driverBytes)
+	driverBytes[0] = 7
+	if b[0] != 1 {
+		t.Error("the blob shares memory with the driver")
+	}
+
+	// a shorter value is scanned into the same memory
+	before := &b[:cap(b)][0]
+	b.Scan([]byte{4})
+	if &b[:cap(b)][0] != before || !bytes.Equal(b, sqlblob{4}) {
+		t.Errorf("got %v, or the memory wasn't reused", b)
+	}
+}
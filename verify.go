@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// verifyTables compares every copied table on both sides, row by row, and
+// prints a report. It returns false if any table doesn't match.
+func verifyTables(pgx *sqlx.Tx, tables []table) (ok bool, err error) {
+	fmt.Println("  > verifying copied tables.")
+
+	ok = true
+	for _, t := range tables {
+		result, err := verifyTable(pgx, t)
+		if err != nil {
+			return false, err
+		}
+		if !strings.HasPrefix(result, "match") {
+			ok = false
+		}
+		fmt.Printf("      %-28s %s\n", t.name, result)
+	}
+
+	return ok, nil
+}
+
+func verifyTable(pgx *sqlx.Tx, t table) (result string, err error) {
+	columns, err := describeTable(pgx, t.name)
+	if err != nil {
+		return "", err
+	}
+
+	columnnames := make([]string, len(columns))
+	for i, col := range columns {
+		columnnames[i] = col.Name
+	}
+	// the key first, then the other columns so rows sharing a key (or with
+	// nulls in it) come in the same order on both sides
+	keys := t.keys()
+	for _, name := range columnnames {
+		inKey := false
+		for _, key := range keys {
+			if key == name {
+				inKey = true
+			}
+		}
+		if !inKey {
+			keys = append(keys, name)
+		}
+	}
+	liteOrder := make([]string, len(keys))
+	pgOrder := make([]string, len(keys))
+	for i, key := range keys {
+		liteOrder[i] = key
+		// sqlite sorts nulls first and compares text bytewise, make postgres do the same
+		pgOrder[i] = key + " NULLS FIRST"
+		for _, col := range columns {
+			if col.Name == key && (col.PGType == "text" || col.PGType == "character varying") {
+				pgOrder[i] = key + ` COLLATE "C" NULLS FIRST`
+			}
+		}
+	}
+
+	query := `SELECT ` + strings.Join(columnnames, ",") + ` FROM ` + t.name + ` ORDER BY `
+	liteRows, err := lite.Query(query + strings.Join(liteOrder, ","))
+	if err != nil {
+		fmt.Println("error selecting "+t.name+" from sqlite", err)
+		return "", err
+	}
+	defer liteRows.Close()
+	pgRows, err := pgx.Query(query + strings.Join(pgOrder, ","))
+	if err != nil {
+		fmt.Println("error selecting "+t.name+" from postgres", err)
+		return "", err
+	}
+	defer pgRows.Close()
+
+	liteTable := sha256.New()
+	pgTable := sha256.New()
+	liteCount := 0
+	pgCount := 0
+	firstMismatch := -1
+	for {
+		liteHash, err := nextRowHash(liteRows, columns)
+		if err != nil {
+			fmt.Println("error reading "+t.name+" from sqlite", err)
+			return "", err
+		}
+		pgHash, err := nextRowHash(pgRows, columns)
+		if err != nil {
+			fmt.Println("error reading "+t.name+" from postgres", err)
+			return "", err
+		}
+		if liteHash == nil && pgHash == nil {
+			break
+		}
+
+		if liteHash != nil {
+			liteTable.Write(liteHash)
+			liteCount++
+		}
+		if pgHash != nil {
+			pgTable.Write(pgHash)
+			pgCount++
+		}
+		if firstMismatch == -1 && string(liteHash) != string(pgHash) {
+			firstMismatch = liteCount
+			if pgCount > liteCount {
+				firstMismatch = pgCount
+			}
+		}
+	}
+
+	liteSum := hex.EncodeToString(liteTable.Sum(nil))
+	pgSum := hex.EncodeToString(pgTable.Sum(nil))
+	switch {
+	case liteCount != pgCount:
+		return fmt.Sprintf("MISMATCH rows sqlite:%d postgres:%d", liteCount, pgCount), nil
+	case liteSum != pgSum:
+		return fmt.Sprintf("MISMATCH %d rows, first difference at row %d", liteCount, firstMismatch), nil
+	default:
+		return fmt.Sprintf("match %d rows sha256:%s", liteCount, liteSum[:16]), nil
+	}
+}
+
+// nextRowHash scans the next row and returns the hash of its canonical form,
+// or nil when there are no more rows.
+func nextRowHash(rows *sql.Rows, columns []column) ([]byte, error) {
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+
+	targets := make([]interface{}, len(columns))
+	for i, col := range columns {
+		targets[i] = col.scanTarget()
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return nil, err
+	}
+
+	return rowHash(targets), nil
+}
+
+// rowHash is the hash of the canonical form of a scanned row.
+func rowHash(targets []interface{}) []byte {
+	h := sha256.New()
+	for _, target := range targets {
+		writeCanonical(h, target)
+	}
+	return h.Sum(nil)
+}
+
+// writeCanonical writes a value in a form that doesn't depend on which database
+// it came from.
+func writeCanonical(h hash.Hash, target interface{}) {
+	var s string
+	switch v := target.(type) {
+	case *sqlblob:
+		if *v == nil {
+			s = "N"
+		} else {
+			s = "b" + hex.EncodeToString(*v)
+		}
+	case *sql.NullInt64:
+		if !v.Valid {
+			s = "N"
+		} else {
+			s = "i" + strconv.FormatInt(v.Int64, 10)
+		}
+	case *sql.NullBool:
+		if !v.Valid {
+			s = "N"
+		} else {
+			s = "t" + strconv.FormatBool(v.Bool)
+		}
+	case *sql.NullFloat64:
+		if !v.Valid {
+			s = "N"
+		} else {
+			s = "f" + strconv.FormatFloat(v.Float64, 'g', -1, 64)
+		}
+	case *sql.NullString:
+		if !v.Valid {
+			s = "N"
+		} else {
+			s = "s" + strconv.Quote(v.String)
+		}
+	}
+	h.Write([]byte(s))
+	h.Write([]byte{0})
+}
+
+// verifyHtlcSigs checks that every channel with htlcs still in flight (not in
+// the final states 9 and 19) has the same htlc signatures on postgres as on
+// sqlite. It returns a description of each channel that doesn't.
+func verifyHtlcSigs(pgq sqlx.Queryer) (problems []string, err error) {
+	var channels []int64
+	err = lite.Select(&channels, `
+SELECT DISTINCT channel_id FROM channel_htlcs
+WHERE hstate NOT IN (9, 19) AND channel_id IS NOT NULL
+ORDER BY channel_id
+    `)
+	if err != nil {
+		fmt.Println("error listing channels with pending htlcs", err)
+		return nil, fmt.Errorf("%w: %s", errSource, err)
+	}
+
+	for _, channel := range channels {
+		var liteSigs, pgSigs [][]byte
+		if err := lite.Select(&liteSigs, "SELECT signature FROM htlc_sigs WHERE channelid = ?", channel); err != nil {
+			fmt.Println("error reading htlc_sigs from sqlite", err)
+			return nil, fmt.Errorf("%w: %s", errSource, err)
+		}
+		if err := sqlx.Select(pgq, &pgSigs, "SELECT signature FROM htlc_sigs WHERE channelid = $1", channel); err != nil {
+			fmt.Println("error reading htlc_sigs from postgres", err)
+			return nil, err
+		}
+
+		// the same signatures, as many times each, in any order
+		count := make(map[string]int, len(liteSigs))
+		for _, sig := range liteSigs {
+			count[string(sig)]++
+		}
+		for _, sig := range pgSigs {
+			count[string(sig)]--
+		}
+		missing, extra := 0, 0
+		for _, n := range count {
+			if n > 0 {
+				missing += n
+			} else {
+				extra -= n
+			}
+		}
+		if missing > 0 || extra > 0 {
+			problems = append(problems, fmt.Sprintf("channel %d: %d of %d signatures missing on postgres, %d unexpected",
+				channel, missing, len(liteSigs), extra))
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"testing"
+)
+
+func TestRowHash(t *testing.T) {
+	blob := sqlblob("1")
+	tests := []struct {
+		name string
+		a    []interface{}
+		b    []interface{}
+		same bool
+	}{
+		{
+			name: "same values",
+			a:    []interface{}{&sql.NullInt64{Int64: 1, Valid: true}, &sql.NullString{String: "x", Valid: true}},
+			b:    []interface{}{&sql.NullInt64{Int64: 1, Valid: true}, &sql.NullString{String: "x", Valid: true}},
+			same: true,
+		},
+		{
+			name: "null is not zero",
+			a:    []interface{}{&sql.NullInt64{}},
+			b:    []interface{}{&sql.NullInt64{Valid: true}},
+		},
+		{
+			name: "null is not empty",
+			a:    []interface{}{&sql.NullString{}},
+			b:    []interface{}{&sql.NullString{Valid: true}},
+		},
+		{
+			name: "types are told apart",
+			a:    []interface{}{&sql.NullInt64{Int64: 1, Valid: true}},
+			b:    []interface{}{&sql.NullString{String: "1", Valid: true}},
+		},
+		{
+			name: "blobs are not strings",
+			a:    []interface{}{&blob},
+			b:    []interface{}{&sql.NullString{String: "1", Valid: true}},
+		},
+		{
+			name: "column boundaries count",
+			a:    []interface{}{&sql.NullString{String: "ab", Valid: true}, &sql.NullString{String: "c", Valid: true}},
+			b:    []interface{}{&sql.NullString{String: "a", Valid: true}, &sql.NullString{String: "bc", Valid: true}},
+		},
+		{
+			name: "booleans and floats",
+			a:    []interface{}{&sql.NullBool{Bool: true, Valid: true}, &sql.NullFloat64{Float64: 0.5, Valid: true}},
+			b:    []interface{}{&sql.NullBool{Bool: true, Valid: true}, &sql.NullFloat64{Float64: 0.5, Valid: true}},
+			same: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			same := string(canonicalHash(tt.a)) == string(canonicalHash(tt.b))
+			if same != tt.same {
+				t.Errorf("same hash = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func canonicalHash(targets []interface{}) []byte {
+	h := sha256.New()
+	for _, target := range targets {
+		writeCanonical(h, target)
+	}
+	return h.Sum(nil)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// table is a table copied from sqlite to postgres. unique is the conflict
+// target used on insert, empty when the table has none.
+type table struct {
+	name   string
+	unique string
+}
+
+// replacedTables have no key, and lightningd rewrites them as a whole anyway,
+// so their rows on postgres are deleted before copying. Otherwise running again
+// would duplicate them.
+var replacedTables = map[string]bool{
+	"htlc_sigs": true,
+}
+
+// keys are the names of the columns in unique.
+func (t table) keys() []string {
+	if t.unique == "" {
+		return nil
+	}
+	keys := strings.Split(t.unique, ",")
+	for i := range keys {
+		keys[i] = strings.TrimSpace(keys[i])
+	}
+	return keys
+}
+
+// fix is a statement run on the sqlite transaction (which is never committed)
+// to rewrite data that postgres wouldn't accept as it is.
+type fix struct {
+	description string
+	query       string
+}
+
+// release describes how to migrate every database version from `from` to `to`,
+// inclusive. to == 0 means there is no known upper bound yet. tables are
+// copied in foreign key order (see sortTables), this order only breaks ties.
+type release struct {
+	from   int
+	to     int
+	tables []table
+	skip   []string // tables intentionally left out of the copy
+	fixes  []fix
+}
+
+func (r release) covers(version int) bool {
+	return version >= r.from && (r.to == 0 || version <= r.to)
+}
+
+func (r release) String() string {
+	if r.to == 0 {
+		return fmt.Sprintf("%d+", r.from)
+	}
+	if r.from == r.to {
+		return fmt.Sprintf("%d", r.from)
+	}
+	return fmt.Sprintf("%d-%d", r.from, r.to)
+}
+
+var releases = []release{
+	{
+		from: 162,
+		to:   162,
+		tables: []table{
+			{"blocks", "height"},
+			{"channel_configs", "id"},
+			{"peers", "id"},
+			{"channels", "id"},
+			{"channel_feerates", "channel_id, hstate"},
+			{"channel_htlcs", "id"},
+			{"transactions", "id"},
+			{"transaction_annotations", "txid, idx"},
+			{"channeltxs", "id"},
+			{"outputs", "prev_out_tx, prev_out_index"},
+			{"payments", "payment_hash, partid"},
+			{"invoices", "id"},
+			{"forwarded_payments", "in_htlc_id, out_htlc_id"},
+			{"shachains", "id"},
+			{"shachain_known", "shachain_id, pos"},
+			{"utxoset", "txid, outnum"},
+			{"penalty_bases", "channel_id, commitnum"},
+			{"channel_state_changes", ""},
+			{"offers", "offer_id"},
+			{"channel_funding_inflights", "channel_id, funding_tx_id"},
+			{"htlc_sigs", ""},
+		},
+		skip: []string{
+			"version",
+			"db_upgrades",
+			"sqlite_sequence",
+			"android_metadata",
+		},
+		fixes: []fix{
+			{
+				// apparently old versions stored a blob in the 'val' column, but this is
+				// no longer needed nor supported in postgres.
+				"vars.val cleared for genesis_hash",
+				`UPDATE vars SET val = NULL WHERE name = 'genesis_hash'`,
+			},
+			{
+				"empty invoices.features rewritten",
+				`UPDATE invoices SET features = '' WHERE length(features) = 0`,
+			},
+		},
+	},
+}
+
+func releaseFor(version int) *release {
+	for i := range releases {
+		if releases[i].covers(version) {
+			return &releases[i]
+		}
+	}
+	return nil
+}
+
+func supportedVersions() string {
+	ranges := make([]string, len(releases))
+	for i, r := range releases {
+		ranges[i] = r.String()
+	}
+	return strings.Join(ranges, ", ")
+}